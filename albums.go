@@ -0,0 +1,816 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/audio"
+	"github.com/tardigradio/website/db"
+)
+
+const (
+	// maxAlbumTracks is the most tracks an album can be uploaded with, each up to the max upload size
+	maxAlbumTracks = 50
+	// releaseDateLayout is the format of date inputs
+	releaseDateLayout = "2006-01-02"
+)
+
+var (
+	errAlbumNotFound      = newAPIError(http.StatusNotFound, "Album not found")
+	errAlbumTitleRequired = newAPIError(http.StatusUnprocessableEntity, "Enter a title for the album")
+	errTracksRequired     = newAPIError(http.StatusUnprocessableEntity, "Choose the audio files of the album's tracks")
+	errTooManyTracks      = newAPIError(http.StatusUnprocessableEntity, fmt.Sprintf("Albums can have at most %d tracks", maxAlbumTracks))
+	errNotInAlbum         = newAPIError(http.StatusUnprocessableEntity, "The song is not a track of the album")
+	errInvalidReleaseDate = newAPIError(http.StatusUnprocessableEntity, "Enter a valid release date")
+)
+
+// AlbumWithMeta contains an album and its links for user.tmpl
+type AlbumWithMeta struct {
+	db.Album
+	URL      string
+	CoverURL string
+	Released string
+}
+
+// AlbumTrackWithMeta contains a track of an album and where it is played from for album.tmpl
+type AlbumTrackWithMeta struct {
+	db.AlbumTrack
+	URL      string
+	AudioURL string
+	Release  string
+}
+
+// apiAlbum is the JSON representation of an album
+type apiAlbum struct {
+	ID          string            `json:"id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Artist      string            `json:"artist"`
+	ReleaseDate string            `json:"releaseDate"`
+	Cover       map[string]string `json:"cover,omitempty"`
+	URL         string            `json:"url"`
+	Created     int               `json:"created"`
+	Updated     int               `json:"updated"`
+	Tracks      []apiSong         `json:"tracks,omitempty"`
+}
+
+// albumUpload is an audio file uploaded as a track of a new album
+type albumUpload struct {
+	header *multipart.FileHeader
+	file   multipart.File
+	info   audio.Info
+	title  string
+	track  int
+}
+
+// albumURL returns the path of an album's page
+func albumURL(album db.Album) string {
+	return "/a/" + url.PathEscape(album.PublicID)
+}
+
+// albumCoverURL returns the path of a thumbnail of an album's cover image, or "" if it has none
+func albumCoverURL(album db.Album, size string) string {
+	return imageURL(album.CoverID, size)
+}
+
+// releaseDay formats the day an album came out
+func releaseDay(album db.Album) string {
+	return time.Unix(album.ReleaseDate, 0).UTC().Format("January 2, 2006")
+}
+
+// albumWithMeta adds the links of an album for templates
+func albumWithMeta(album db.Album) *AlbumWithMeta {
+	return &AlbumWithMeta{Album: album, URL: albumURL(album), CoverURL: albumCoverURL(album, "medium"), Released: releaseDay(album)}
+}
+
+// releaseDateParam reads the day an album came out from a form, today unless chosen otherwise
+func releaseDateParam(c *gin.Context) (int64, error) {
+	value := strings.TrimSpace(c.PostForm("releaseDate"))
+	if value == "" {
+		now := time.Now().UTC()
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Unix(), nil
+	}
+
+	day, err := time.Parse(releaseDateLayout, value)
+	if err != nil {
+		return 0, errInvalidReleaseDate
+	}
+
+	return day.Unix(), nil
+}
+
+// ownAlbumParam loads one of user's albums by its public ID
+func (s *Server) ownAlbumParam(user db.User, publicID string) (db.Album, error) {
+	album, err := s.DB.GetAlbumByPublicID(publicID)
+	if err == sql.ErrNoRows || (err == nil && album.UserID != user.ID) {
+		return db.Album{}, errAlbumNotFound
+	}
+
+	return album, err
+}
+
+// albumParam loads the album in the :id route parameter along with the tracks user may see.
+// Albums without any tracks the user may see are only found by the artist.
+func (s *Server) albumParam(c *gin.Context, user db.User) (db.Album, []db.AlbumTrack, error) {
+	album, err := s.DB.GetAlbumByPublicID(c.Param("id"))
+	if err == sql.ErrNoRows {
+		return db.Album{}, nil, errAlbumNotFound
+	}
+	if err != nil {
+		return db.Album{}, nil, err
+	}
+
+	tracks, err := s.visibleAlbumTracks(user.ID, album)
+	if err != nil {
+		return db.Album{}, nil, err
+	}
+
+	if len(tracks) == 0 && user.ID != album.UserID {
+		return db.Album{}, nil, errAlbumNotFound
+	}
+
+	return album, tracks, nil
+}
+
+// visibleAlbumTracks returns the tracks of an album which a user may see, in order
+func (s *Server) visibleAlbumTracks(userID int, album db.Album) ([]db.AlbumTrack, error) {
+	tracks, err := s.DB.GetAlbumTracks(album.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlisted tracks need their own secret link, which an album does not give
+	var visible []db.AlbumTrack
+	for _, track := range tracks {
+		if canView(userID, track.Song, "") {
+			visible = append(visible, track)
+		}
+	}
+
+	return visible, nil
+}
+
+// visibleAlbums returns the albums of an artist which have tracks a user may see
+func (s *Server) visibleAlbums(userID int, artist db.User) ([]db.Album, error) {
+	albums, err := s.DB.GetAlbumsForUser(artist.ID)
+	if err != nil || userID == artist.ID {
+		return albums, err
+	}
+
+	var visible []db.Album
+	for _, album := range albums {
+		tracks, err := s.visibleAlbumTracks(userID, album)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tracks) > 0 {
+			visible = append(visible, album)
+		}
+	}
+
+	return visible, nil
+}
+
+// albumTrackByPublicID finds a track of an album by its song's public ID
+func (s *Server) albumTrackByPublicID(album db.Album, publicID string) (db.AlbumTrack, error) {
+	tracks, err := s.DB.GetAlbumTracks(album.ID)
+	if err != nil {
+		return db.AlbumTrack{}, err
+	}
+
+	for _, track := range tracks {
+		if track.PublicID == publicID {
+			return track, nil
+		}
+	}
+
+	return db.AlbumTrack{}, errNotInAlbum
+}
+
+// openAlbumUploads opens and reads the tags of the track files of an album upload.
+// Tracks are ordered by their tagged track numbers if every file has a different one, otherwise in the order they were chosen.
+// The caller closes the files.
+func openAlbumUploads(headers []*multipart.FileHeader) ([]*albumUpload, error) {
+	var uploads []*albumUpload
+
+	numbered := make(map[int]bool, len(headers))
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			closeAlbumUploads(uploads)
+			return nil, err
+		}
+
+		upload := &albumUpload{header: header, file: file}
+		uploads = append(uploads, upload)
+
+		info, err := probeUpload(file, header.Size)
+		if err == errUnsupportedFormat {
+			err = newAPIError(errUnsupportedFormat.Status, fmt.Sprintf("%s: %s", path.Base(header.Filename), errUnsupportedFormat.Message))
+		}
+		if err != nil {
+			closeAlbumUploads(uploads)
+			return nil, err
+		}
+
+		// Files without a tagged title are named after the file
+		upload.title = strings.TrimSpace(info.Title)
+		if upload.title == "" {
+			name := path.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+			upload.title = strings.TrimSpace(strings.TrimSuffix(name, path.Ext(name)))
+		}
+
+		upload.info = info
+		upload.track = info.Track
+		if upload.track > 0 {
+			numbered[upload.track] = true
+		}
+	}
+
+	if len(numbered) == len(uploads) {
+		sort.SliceStable(uploads, func(i, j int) bool { return uploads[i].track < uploads[j].track })
+	}
+
+	return uploads, nil
+}
+
+// closeAlbumUploads closes the files opened by openAlbumUploads
+func closeAlbumUploads(uploads []*albumUpload) {
+	for _, upload := range uploads {
+		upload.file.Close()
+	}
+}
+
+// uploadAlbum creates an album from a multipart form with title, description, releaseDate, cover and files fields.
+// Every file becomes a track with the license, visibility and release time chosen in the form.
+// Nothing is kept if any of the tracks cannot be saved.
+func (s *Server) uploadAlbum(c *gin.Context, user db.User) (db.Album, error) {
+	if err := s.parseUpload(c); err != nil {
+		return db.Album{}, err
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		return db.Album{}, errAlbumTitleRequired
+	}
+
+	releaseDate, err := releaseDateParam(c)
+	if err != nil {
+		return db.Album{}, err
+	}
+
+	license, err := licenseParam(c)
+	if err != nil {
+		return db.Album{}, err
+	}
+
+	access, err := accessParam(c)
+	if err != nil {
+		return db.Album{}, err
+	}
+
+	cover, err := preparedImageParam(c, "cover")
+	if err != nil {
+		return db.Album{}, err
+	}
+
+	headers := c.Request.MultipartForm.File["files"]
+	if len(headers) == 0 {
+		return db.Album{}, errTracksRequired
+	}
+	if len(headers) > maxAlbumTracks {
+		return db.Album{}, errTooManyTracks
+	}
+
+	var size int64
+	for _, header := range headers {
+		if header.Size > s.maxUploadSize {
+			return db.Album{}, newAPIError(http.StatusRequestEntityTooLarge, fmt.Sprintf("%s: %s", path.Base(header.Filename), errUploadTooLarge(s.maxUploadSize)))
+		}
+		size += header.Size
+	}
+	if cover != nil {
+		size += cover.Size
+	}
+
+	// Every track is probed before anything is stored, so a file which is not audio leaves nothing behind
+	uploads, err := openAlbumUploads(headers)
+	if err != nil {
+		return db.Album{}, err
+	}
+	defer closeAlbumUploads(uploads)
+
+	release, err := s.reserveQuota(user, size)
+	if err != nil {
+		return db.Album{}, err
+	}
+	defer release()
+
+	var coverID string
+	if cover != nil {
+		picture, err := s.saveImage(c, user, cover)
+		if err != nil {
+			return db.Album{}, err
+		}
+		coverID = picture.PublicID
+	}
+
+	id, err := s.DB.AddAlbum(user.ID, title, strings.TrimSpace(c.PostForm("description")), releaseDate, coverID)
+	if err != nil {
+		if deleteErr := s.deleteImage(c, user, coverID); deleteErr != nil {
+			log.Printf("Failed to delete unused image %s: %s\n", coverID, deleteErr)
+		}
+		return db.Album{}, err
+	}
+
+	album, err := s.DB.GetAlbum(int(id))
+	if err != nil {
+		return db.Album{}, err
+	}
+
+	for _, upload := range uploads {
+		song, err := s.storeSong(c, user, upload.title, "", license, access, upload.header.Filename, upload.file, upload.header.Size, upload.info)
+		if err == nil {
+			if err = s.DB.AddAlbumTrack(album.ID, song.ID); err != nil {
+				s.discardSong(c, user, song)
+			}
+		}
+		if err != nil {
+			if deleteErr := s.deleteAlbum(c, user, album, true); deleteErr != nil {
+				log.Printf("Failed to remove album %d after a failed upload: %s\n", album.ID, deleteErr)
+			}
+			return db.Album{}, err
+		}
+	}
+
+	return s.DB.GetAlbum(album.ID)
+}
+
+// discardSong deletes a song which was saved but could not be added to its album
+func (s *Server) discardSong(ctx context.Context, user db.User, song db.Song) {
+	if err := s.deleteSong(ctx, user, song); err != nil {
+		log.Printf("Failed to delete unused song %d: %s\n", song.ID, err)
+	}
+}
+
+// deleteAlbum removes one of user's albums and its cover, along with its tracks if withTracks is set
+func (s *Server) deleteAlbum(ctx context.Context, user db.User, album db.Album, withTracks bool) error {
+	if withTracks {
+		tracks, err := s.DB.GetAlbumTracks(album.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, track := range tracks {
+			if err := s.deleteSong(ctx, user, track.Song); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.DB.DeleteAlbum(album.ID); err != nil {
+		return err
+	}
+
+	return s.deleteImage(ctx, user, album.CoverID)
+}
+
+// GetAlbum gets the page of an album
+func (s *Server) GetAlbum(c *gin.Context) {
+	user, _ := s.getCurrentUserFromDbBy(c)
+
+	album, tracks, err := s.albumParam(c, user)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	s.renderAlbum(c, http.StatusOK, user, album, tracks, nil)
+}
+
+// renderAlbum renders album.tmpl with extra variables such as Error
+func (s *Server) renderAlbum(c *gin.Context, status int, user db.User, album db.Album, tracks []db.AlbumTrack, extra gin.H) {
+	artist, err := s.DB.GetUserByID(album.UserID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var withMeta []*AlbumTrackWithMeta
+	for _, track := range tracks {
+		withMeta = append(withMeta, &AlbumTrackWithMeta{
+			AlbumTrack: track,
+			URL:        songURL(artist.Username, track.Song),
+			AudioURL:   songAudioURL(artist.Username, track.Song),
+			Release:    releaseTime(track.Song),
+		})
+	}
+
+	vars := gin.H{
+		"album":       album,
+		"albumURL":    albumURL(album),
+		"coverURL":    albumCoverURL(album, "large"),
+		"artist":      artist.Username,
+		"released":    releaseDay(album),
+		"releaseDate": time.Unix(album.ReleaseDate, 0).UTC().Format(releaseDateLayout),
+		"tracks":      withMeta,
+		"isOwner":     user.ID != 0 && user.ID == album.UserID,
+	}
+
+	if user.ID != 0 {
+		vars["currentUser"] = user.Username
+	}
+
+	for key, value := range extra {
+		vars[key] = value
+	}
+
+	renderHTML(c, status, "album.tmpl", vars)
+}
+
+// GetAlbumCover redirects to the large thumbnail of an album's cover image
+func (s *Server) GetAlbumCover(c *gin.Context) {
+	user, _ := s.getCurrentUserFromDbBy(c)
+
+	album, _, err := s.albumParam(c, user)
+	if err == nil && album.CoverID == "" {
+		err = errImageNotFound
+	}
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusMovedPermanently, albumCoverURL(album, "large"))
+}
+
+// GetNewAlbum gets the page for uploading an album
+func (s *Server) GetNewAlbum(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderNewAlbum(c, http.StatusOK, user, "")
+}
+
+// renderNewAlbum renders newalbum.tmpl, with message as the Error if it is not blank
+func (s *Server) renderNewAlbum(c *gin.Context, status int, user db.User, message string) {
+	vars, err := s.storageVariables(user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	vars["currentUser"] = user.Username
+	vars["licenses"] = licenses
+	vars["defaultLicense"] = defaultLicense
+	vars["visibilities"] = visibilities
+	vars["maxAlbumTracks"] = maxAlbumTracks
+	vars["maxAlbumSize"] = humanize.Bytes(uint64(s.maxAlbumSize))
+	vars["maxAlbumBytes"] = s.maxAlbumSize
+	if message != "" {
+		vars["Error"] = message
+	}
+
+	renderHTML(c, status, "newalbum.tmpl", vars)
+}
+
+// PostAlbum uploads an album with all of its tracks
+func (s *Server) PostAlbum(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	album, err := s.uploadAlbum(c, user)
+	if apiErr, ok := err.(*apiError); ok {
+		s.renderNewAlbum(c, apiErr.Status, user, apiErr.Message)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, albumURL(album))
+}
+
+// PostEditAlbum changes the title, description and release date of one of the current user's albums,
+// and replaces its cover if a new one was uploaded
+func (s *Server) PostEditAlbum(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	album, err := s.ownAlbumParam(user, c.Param("id"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	err = s.editAlbum(c, user, album)
+	if apiErr, ok := err.(*apiError); ok {
+		tracks, err := s.visibleAlbumTracks(user.ID, album)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		s.renderAlbum(c, apiErr.Status, user, album, tracks, gin.H{"Error": apiErr.Message})
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, albumURL(album))
+}
+
+// editAlbum saves the changes to an album from a form with title, description, releaseDate and cover fields
+func (s *Server) editAlbum(c *gin.Context, user db.User, album db.Album) error {
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		return errAlbumTitleRequired
+	}
+
+	releaseDate, err := releaseDateParam(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.replaceImage(c, user, "cover", album.CoverID, func(publicID string) error {
+		return s.DB.SetAlbumCover(album.ID, publicID)
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.DB.UpdateAlbum(album.ID, title, strings.TrimSpace(c.PostForm("description")), releaseDate)
+}
+
+// PostDeleteAlbum deletes one of the current user's albums.
+// Its tracks are kept as songs on their own unless deleteTracks is set in the form.
+func (s *Server) PostDeleteAlbum(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	album, err := s.ownAlbumParam(user, c.Param("id"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if err := s.deleteAlbum(c, user, album, c.PostForm("deleteTracks") != ""); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/user/"+url.PathEscape(user.Username))
+}
+
+// PostRemoveAlbumTrack takes the song chosen in the form off one of the current user's albums
+func (s *Server) PostRemoveAlbumTrack(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	album, err := s.ownAlbumParam(user, c.Param("id"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	track, err := s.albumTrackByPublicID(album, c.PostForm("song"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if err := s.DB.RemoveAlbumTrack(album.ID, track.ID); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, albumURL(album))
+}
+
+// PostAlbumOrder saves the order tracks were dragged into, sent as comma separated public IDs in the songs field
+func (s *Server) PostAlbumOrder(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	album, err := s.ownAlbumParam(user, c.Param("id"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	tracks, err := s.DB.GetAlbumTracks(album.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ids := make(map[string]int, len(tracks))
+	for _, track := range tracks {
+		ids[track.PublicID] = track.ID
+	}
+
+	var order []int
+	for _, publicID := range strings.Split(c.PostForm("songs"), ",") {
+		publicID = strings.TrimSpace(publicID)
+		if publicID == "" {
+			continue
+		}
+
+		id, ok := ids[publicID]
+		if !ok {
+			c.String(errNotInAlbum.Status, errNotInAlbum.Error())
+			return
+		}
+		order = append(order, id)
+	}
+
+	if err := s.reorderAlbum(album, order); err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// reorderAlbum saves a new order of an album's tracks
+func (s *Server) reorderAlbum(album db.Album, songIDs []int) error {
+	err := s.DB.ReorderAlbum(album.ID, songIDs)
+	if err == db.ErrNotInAlbum {
+		return errNotInAlbum
+	}
+
+	return err
+}
+
+// PostSongAlbum moves the song in the :id route parameter to the end of the album chosen in the form,
+// or takes it off its album if none was chosen
+func (s *Server) PostSongAlbum(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	song, err := s.ownSongParam(user, c.Param("id"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if c.PostForm("album") == "" {
+		current, _, err := s.DB.GetSongAlbum(song.ID)
+		if err == nil {
+			err = s.DB.RemoveAlbumTrack(current.ID, song.ID)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, songURL(user.Username, song))
+		return
+	}
+
+	album, err := s.ownAlbumParam(user, c.PostForm("album"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if err := s.DB.AddAlbumTrack(album.ID, song.ID); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, albumURL(album))
+}
+
+// apiAlbumFrom converts an album and its tracks for the API
+func (s *Server) apiAlbumFrom(album db.Album, artist string, tracks []db.AlbumTrack) apiAlbum {
+	result := apiAlbum{
+		ID:          album.PublicID,
+		Title:       album.Title,
+		Description: album.Description,
+		Artist:      artist,
+		ReleaseDate: time.Unix(album.ReleaseDate, 0).UTC().Format(releaseDateLayout),
+		Cover:       apiImageFrom(album.CoverID),
+		URL:         albumURL(album),
+		Created:     album.Created,
+		Updated:     album.Updated,
+	}
+
+	for _, track := range tracks {
+		result.Tracks = append(result.Tracks, s.apiSongFrom(track.Song, artist))
+	}
+
+	return result
+}
+
+// APIGetAlbum returns an album with its tracks, in order
+func (s *Server) APIGetAlbum(c *gin.Context) {
+	user, _ := s.apiCurrentUser(c)
+
+	album, tracks, err := s.albumParam(c, user)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	artist, err := s.DB.GetUserByID(album.UserID)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	result := s.apiAlbumFrom(album, artist.Username, tracks)
+	if result.Tracks == nil {
+		result.Tracks = []apiSong{}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// APIGetUserAlbums returns the albums of a user, newest first
+func (s *Server) APIGetUserAlbums(c *gin.Context) {
+	artist, err := s.DB.GetUserByName(c.Param("name"))
+	if err == sql.ErrNoRows {
+		apiAbort(c, errAPIUserNotFound)
+		return
+	}
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	user, _ := s.apiCurrentUser(c)
+
+	released, err := s.visibleAlbums(user.ID, artist)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	albums := []apiAlbum{}
+	for _, album := range released {
+		albums = append(albums, s.apiAlbumFrom(album, artist.Username, nil))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"albums": albums})
+}
+
+// APIPostAlbum uploads an album from a multipart form with title, description, releaseDate, cover,
+// license, visibility, releaseAt and one files field for each track
+func (s *Server) APIPostAlbum(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	album, err := s.uploadAlbum(c, user)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	tracks, err := s.DB.GetAlbumTracks(album.ID)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, s.apiAlbumFrom(album, user.Username, tracks))
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// apiError is an error that should be reported to clients with a specific HTTP status
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// newAPIError creates an apiError with a status and message for the client
+func newAPIError(status int, message string) *apiError {
+	return &apiError{Status: status, Message: message}
+}
+
+var (
+	errAPIUnauthorized    = newAPIError(http.StatusUnauthorized, "You must be logged in")
+	errAPISongNotFound    = newAPIError(http.StatusNotFound, "Song not found")
+	errAPIUserNotFound    = newAPIError(http.StatusNotFound, "User not found")
+	errAPICommentNotFound = newAPIError(http.StatusNotFound, "Comment not found")
+	errTitleRequired      = newAPIError(http.StatusUnprocessableEntity, "A title is required")
+)
+
+// statusFor returns the HTTP status an error should be reported with
+func statusFor(err error) int {
+	if apiErr, ok := err.(*apiError); ok {
+		return apiErr.Status
+	}
+
+	if err == sql.ErrNoRows || err == ErrObjectNotFound {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// apiAbort writes an error body and stops the handler chain.
+// Every API error has the form {"error": {"status": 404, "message": "..."}}.
+func apiAbort(c *gin.Context, err error) {
+	status := statusFor(err)
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = http.StatusText(status)
+		c.Error(err)
+	}
+
+	c.AbortWithStatusJSON(status, gin.H{
+		"error": gin.H{
+			"status":  status,
+			"message": message,
+		},
+	})
+}
+
+// APIAuthOptional is a handler for public API routes which checks an API token if one was sent,
+// so token owners see their own private songs. Invalid tokens are rejected rather than treated as anonymous.
+func APIAuthOptional(server *Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := server.authenticateToken(c); err != nil {
+			apiAbort(c, err)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// APIAuthRequired is a handler that requires users to be logged in or send an API token for API routes
+func APIAuthRequired(server *Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if usedToken, err := server.authenticateToken(c); usedToken {
+			if err != nil {
+				apiAbort(c, err)
+				return
+			}
+
+			c.Next()
+			return
+		}
+
+		if _, err := server.getCurrentUserFromDbBy(c); err != nil {
+			apiAbort(c, errAPIUnauthorized)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// apiUser is the JSON representation of a user
+type apiUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Created  int    `json:"created"`
+	Email    string `json:"email,omitempty"`
+	// Avatar is the profile picture at each thumbnail size, the user's Gravatar if they have not uploaded one
+	Avatar map[string]string `json:"avatar"`
+}
+
+// apiSong is the JSON representation of a song
+type apiSong struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Artist      string `json:"artist"`
+	Created     int    `json:"created"`
+	Likes       int    `json:"likes"`
+	Comments    int    `json:"comments"`
+	Audio       string `json:"audio"`
+	PublicID    string `json:"publicId"`
+	URL         string `json:"url"`
+
+	Duration   float64 `json:"duration"`
+	Bitrate    int     `json:"bitrate"`
+	SampleRate int     `json:"sampleRate"`
+	Channels   int     `json:"channels"`
+	Codec      string  `json:"codec"`
+	Tags       apiTags `json:"tags"`
+
+	License apiLicense `json:"license"`
+
+	Visibility string `json:"visibility"`
+	ReleaseAt  int64  `json:"releaseAt,omitempty"`
+
+	Cover map[string]string `json:"cover,omitempty"`
+}
+
+// apiLicense is the license a song is released under
+type apiLicense struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	URL          string `json:"url,omitempty"`
+	Downloadable bool   `json:"downloadable"`
+}
+
+// apiTags are the tags embedded in a song's audio file
+type apiTags struct {
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	Track  int    `json:"track"`
+}
+
+// apiComment is the JSON representation of a comment and its replies
+type apiComment struct {
+	ID      int           `json:"id"`
+	Text    string        `json:"text"`
+	Author  string        `json:"author"`
+	Created int           `json:"created"`
+	ReplyTo int           `json:"replyTo,omitempty"`
+	Likes   int           `json:"likes"`
+	Replies []*apiComment `json:"replies"`
+}
+
+// apiLicenseFrom converts a license from the catalog to its JSON representation
+func apiLicenseFrom(license License) apiLicense {
+	return apiLicense{ID: license.ID, Name: license.Name, URL: license.URL, Downloadable: license.Downloadable}
+}
+
+// apiSongFrom converts a song for the API
+func (s *Server) apiSongFrom(song db.Song, artist string) apiSong {
+	return apiSong{
+		ID:          song.ID,
+		Title:       song.Title,
+		Description: song.Description,
+		Artist:      artist,
+		Created:     song.Created,
+		Likes:       s.DB.RefLikeCount(song.ID, db.SongType),
+		Comments:    s.DB.CommentCount(song.ID),
+		Audio:       "/api/v1/songs/" + strconv.Itoa(song.ID) + "/audio",
+		PublicID:    song.PublicID,
+		URL:         songURL(artist, song),
+		Duration:    song.Duration,
+		Bitrate:     song.Bitrate,
+		SampleRate:  song.SampleRate,
+		Channels:    song.Channels,
+		Codec:       song.Codec,
+		Tags:        apiTags{Artist: song.Artist, Album: song.Album, Track: song.Track},
+		License:     apiLicenseFrom(songLicense(song)),
+		Visibility:  song.Visibility,
+		ReleaseAt:   song.ReleaseAt,
+		Cover:       apiImageFrom(song.CoverID),
+	}
+}
+
+// apiCommentsFrom converts comment threads for the API
+func (s *Server) apiCommentsFrom(threads []*db.CommentThread) []*apiComment {
+	comments := []*apiComment{}
+
+	for _, thread := range threads {
+		comments = append(comments, &apiComment{
+			ID:      thread.Comment.ID,
+			Text:    thread.Comment.Text,
+			Author:  thread.Author,
+			Created: thread.Comment.Created,
+			ReplyTo: thread.Comment.CommentID,
+			Likes:   s.DB.RefLikeCount(thread.Comment.ID, db.CommentType),
+			Replies: s.apiCommentsFrom(thread.Replies),
+		})
+	}
+
+	return comments
+}
+
+// apiCurrentUser loads the logged in user for an API request
+func (s *Server) apiCurrentUser(c *gin.Context) (db.User, error) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		return user, errAPIUnauthorized
+	}
+
+	return user, nil
+}
+
+// apiIDParam parses a numeric route parameter
+func apiIDParam(c *gin.Context, name string) (int, error) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id <= 0 {
+		return 0, newAPIError(http.StatusUnprocessableEntity, "Invalid "+name)
+	}
+
+	return id, nil
+}
+
+// apiSongParam loads the song and artist for the :id route parameter
+func (s *Server) apiSongParam(c *gin.Context) (db.Song, db.User, error) {
+	id, err := apiIDParam(c, "id")
+	if err != nil {
+		return db.Song{}, db.User{}, err
+	}
+
+	song, err := s.DB.GetSong(id)
+	if err == sql.ErrNoRows || (err == nil && !s.canViewSong(c, song)) {
+		return db.Song{}, db.User{}, errAPISongNotFound
+	}
+	if err != nil {
+		return db.Song{}, db.User{}, err
+	}
+
+	artist, err := s.DB.GetUserByID(song.UserID)
+	if err != nil {
+		return db.Song{}, db.User{}, err
+	}
+
+	return song, artist, nil
+}
+
+// APIGetMe returns the logged in user
+func (s *Server) APIGetMe(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, apiUser{ID: user.ID, Username: user.Username, Created: user.Created, Email: user.Email, Avatar: apiAvatarFrom(user)})
+}
+
+// APIGetUser returns a user by name
+func (s *Server) APIGetUser(c *gin.Context) {
+	user, err := s.DB.GetUserByName(c.Param("name"))
+	if err == sql.ErrNoRows {
+		apiAbort(c, errAPIUserNotFound)
+		return
+	}
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	var viewerID int
+	if viewer, err := s.apiCurrentUser(c); err == nil {
+		viewerID = viewer.ID
+	}
+
+	c.JSON(http.StatusOK, s.apiProfileFrom(user, viewerID))
+}
+
+// APIGetUserSongs returns every song uploaded by a user
+func (s *Server) APIGetUserSongs(c *gin.Context) {
+	user, err := s.DB.GetUserByName(c.Param("name"))
+	if err == sql.ErrNoRows {
+		apiAbort(c, errAPIUserNotFound)
+		return
+	}
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	var hidden bool
+	if current, err := s.apiCurrentUser(c); err == nil {
+		hidden = current.ID == user.ID
+	}
+
+	uploads, err := s.DB.GetSongsForUser(user.ID, hidden)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	songs := []apiSong{}
+	for _, song := range uploads {
+		songs = append(songs, s.apiSongFrom(song, user.Username))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"songs": songs})
+}
+
+// APIGetRecentSongs returns the most recently uploaded songs
+func (s *Server) APIGetRecentSongs(c *gin.Context) {
+	recent, err := s.DB.GetRecentSongs()
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	songs := []apiSong{}
+	for _, song := range recent {
+		artist, err := s.DB.GetUserByID(song.UserID)
+		if err != nil {
+			apiAbort(c, err)
+			return
+		}
+
+		songs = append(songs, s.apiSongFrom(song, artist.Username))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"songs": songs})
+}
+
+// APIGetSong returns a song by id
+func (s *Server) APIGetSong(c *gin.Context) {
+	song, artist, err := s.apiSongParam(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, s.apiSongFrom(song, artist.Username))
+}
+
+// APIGetSongAudio streams a song's audio
+func (s *Server) APIGetSongAudio(c *gin.Context) {
+	song, artist, err := s.apiSongParam(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	s.serveSong(c, artist.Username, song)
+}
+
+// APIPostSong uploads a song from a multipart form with title, description, license, visibility, releaseAt and file fields
+func (s *Server) APIPostSong(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	song, err := s.uploadSong(c, user, c.PostForm("title"), c.PostForm("description"))
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, s.apiSongFrom(song, user.Username))
+}
+
+// APIDeleteSong deletes one of the logged in user's songs
+func (s *Server) APIDeleteSong(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	song, _, err := s.apiSongParam(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	if song.UserID != user.ID {
+		apiAbort(c, newAPIError(http.StatusForbidden, "You cannot delete this song"))
+		return
+	}
+
+	err = s.deleteSong(c, user, song)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// APIGetComments returns the comment threads on a song
+func (s *Server) APIGetComments(c *gin.Context) {
+	song, _, err := s.apiSongParam(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	threads, err := s.DB.GetCommentsForSong(song.ID)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"comments": s.apiCommentsFrom(threads)})
+}
+
+// APIPostComment comments on a song from a JSON body {"text": "...", "replyTo": 0}
+func (s *Server) APIPostComment(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	songID, err := apiIDParam(c, "id")
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	var body struct {
+		Text    string `json:"text"`
+		ReplyTo int    `json:"replyTo"`
+	}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apiAbort(c, newAPIError(http.StatusUnprocessableEntity, "Invalid comment body"))
+		return
+	}
+
+	_, id, err := s.addComment(user, songID, body.ReplyTo, body.Text, shareKey(c))
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	comment, err := s.DB.GetComment(int(id))
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, apiComment{
+		ID:      comment.ID,
+		Text:    comment.Text,
+		Author:  user.Username,
+		Created: comment.Created,
+		ReplyTo: comment.CommentID,
+		Replies: []*apiComment{},
+	})
+}
+
+// APIDeleteComment deletes a comment and its replies
+func (s *Server) APIDeleteComment(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	commentID, err := apiIDParam(c, "id")
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	_, err = s.deleteComment(user, commentID)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// APIGetSongLikes returns a song's like count and whether the logged in user liked it
+func (s *Server) APIGetSongLikes(c *gin.Context) {
+	s.apiGetLikes(c, db.SongType)
+}
+
+// APIGetCommentLikes returns a comment's like count and whether the logged in user liked it
+func (s *Server) APIGetCommentLikes(c *gin.Context) {
+	s.apiGetLikes(c, db.CommentType)
+}
+
+// APIPutSongLike likes a song
+func (s *Server) APIPutSongLike(c *gin.Context) {
+	s.apiSetLike(c, db.SongType, true)
+}
+
+// APIDeleteSongLike removes a like from a song
+func (s *Server) APIDeleteSongLike(c *gin.Context) {
+	s.apiSetLike(c, db.SongType, false)
+}
+
+// APIPutCommentLike likes a comment
+func (s *Server) APIPutCommentLike(c *gin.Context) {
+	s.apiSetLike(c, db.CommentType, true)
+}
+
+// APIDeleteCommentLike removes a like from a comment
+func (s *Server) APIDeleteCommentLike(c *gin.Context) {
+	s.apiSetLike(c, db.CommentType, false)
+}
+
+// apiLikeTarget checks that the :id route parameter refers to a song, or a comment on a song, the user can see
+func (s *Server) apiLikeTarget(c *gin.Context, likeType int) (int, error) {
+	id, err := apiIDParam(c, "id")
+	if err != nil {
+		return 0, err
+	}
+
+	return id, s.checkLikeTarget(c, likeType, id)
+}
+
+// checkLikeTarget checks that id is a song, or a comment on a song, the user can see
+func (s *Server) checkLikeTarget(c *gin.Context, likeType, id int) error {
+	songID := id
+	if likeType == db.CommentType {
+		comment, err := s.DB.GetComment(id)
+		if err == sql.ErrNoRows {
+			return errAPICommentNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		songID = comment.SongID
+	}
+
+	song, err := s.DB.GetSong(songID)
+	if err == sql.ErrNoRows || (err == nil && !s.canViewSong(c, song)) {
+		if likeType == db.CommentType {
+			return errAPICommentNotFound
+		}
+		return errAPISongNotFound
+	}
+
+	return err
+}
+
+// apiGetLikes reports the likes on a song or comment
+func (s *Server) apiGetLikes(c *gin.Context, likeType int) {
+	id, err := s.apiLikeTarget(c, likeType)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	liked := false
+	if user, err := s.apiCurrentUser(c); err == nil {
+		liked = s.DB.IsLiked(user.ID, id, likeType)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count": s.DB.RefLikeCount(id, likeType),
+		"liked": liked,
+	})
+}
+
+// apiSetLike idempotently likes or unlikes a song or comment
+func (s *Server) apiSetLike(c *gin.Context, likeType int, like bool) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	id, err := s.apiLikeTarget(c, likeType)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	isLiked := s.DB.IsLiked(user.ID, id, likeType)
+
+	if like && !isLiked {
+		err = s.DB.Like(user.ID, id, likeType)
+	} else if !like && isLiked {
+		err = s.DB.Dislike(user.ID, id, likeType)
+	}
+
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count": s.DB.RefLikeCount(id, likeType),
+		"liked": like,
+	})
+}
@@ -0,0 +1,153 @@
+// Package audio reads stream properties and embedded tags from uploaded audio files.
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// ErrUnknownFormat is returned by Probe when the file is not MP3, FLAC, Ogg, MP4 or WAV audio
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// Info describes an audio stream and the tags embedded in its file
+type Info struct {
+	Codec      string
+	Duration   time.Duration
+	Bitrate    int // bits per second
+	SampleRate int // Hz
+	Channels   int
+
+	Title  string
+	Artist string
+	Album  string
+	Track  int
+}
+
+// Probe reads the stream properties and tags of an audio file of size bytes.
+// Tags are still returned along with ErrUnknownFormat if the stream could not be parsed.
+func Probe(r io.ReadSeeker, size int64) (Info, error) {
+	var info Info
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return info, err
+	}
+
+	if m, err := tag.ReadFrom(r); err == nil {
+		info.Title = m.Title()
+		info.Artist = m.Artist()
+		info.Album = m.Album()
+		info.Track, _ = m.Track()
+	}
+
+	// Audio starts after any ID3v2 tag, even in FLAC files
+	start, err := skipID3v2(r)
+	if err != nil {
+		return info, err
+	}
+
+	header, err := readAt(r, start, 12)
+	if err != nil {
+		return info, ErrUnknownFormat
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		err = probeFLAC(r, start, &info)
+	case bytes.HasPrefix(header, []byte("OggS")):
+		err = probeOgg(r, start, size, &info)
+	case bytes.Equal(header[4:8], []byte("ftyp")):
+		err = probeMP4(r, start, size, &info)
+	case bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		err = probeWAV(r, start, size, &info)
+	default:
+		err = probeMPEG(r, start, size, &info)
+	}
+
+	if err != nil {
+		return info, err
+	}
+
+	// Fall back to the average bitrate of the whole file
+	if info.Bitrate == 0 && info.Duration > 0 {
+		info.Bitrate = int(float64(size-start) * 8 / info.Duration.Seconds())
+	}
+
+	return info, nil
+}
+
+// skipID3v2 returns the offset just after an ID3v2 tag at the start of the file, or 0 if there is none
+func skipID3v2(r io.ReadSeeker) (int64, error) {
+	header, err := readAt(r, 0, 10)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !bytes.HasPrefix(header, []byte("ID3")) {
+		return 0, nil
+	}
+
+	// The tag size is a 28 bit syncsafe integer
+	size := int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 | int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f)
+	size += 10
+
+	// A footer repeats the header at the end of the tag
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+
+	return size, nil
+}
+
+// readAt reads exactly n bytes at offset
+func readAt(r io.ReadSeeker, offset int64, n int) ([]byte, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// seconds converts a sample count at rate into a duration
+func seconds(samples int64, rate int64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(samples) / float64(rate) * float64(time.Second))
+}
+
+// probeFLAC reads the STREAMINFO block which must come first in a FLAC stream
+func probeFLAC(r io.ReadSeeker, start int64, info *Info) error {
+	block, err := readAt(r, start+4, 4+34)
+	if err != nil {
+		return ErrUnknownFormat
+	}
+
+	if block[0]&0x7f != 0 {
+		return ErrUnknownFormat
+	}
+
+	streamInfo := block[4:]
+	sampleRate := int64(streamInfo[10])<<12 | int64(streamInfo[11])<<4 | int64(streamInfo[12])>>4
+	channels := int(streamInfo[12]>>1&0x07) + 1
+	samples := int64(streamInfo[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(streamInfo[14:18]))
+
+	info.Codec = "flac"
+	info.SampleRate = int(sampleRate)
+	info.Channels = channels
+	info.Duration = seconds(samples, sampleRate)
+	return nil
+}
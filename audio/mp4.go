@@ -0,0 +1,133 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// maxAtomDepth stops malformed files from nesting atoms forever
+const maxAtomDepth = 8
+
+// mp4Codecs maps audio sample entry types to codec names
+var mp4Codecs = map[string]string{
+	"mp4a": "aac",
+	"alac": "alac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	".mp3": "mp3",
+}
+
+// mp4Containers are the atoms which hold the atoms probeMP4 reads
+var mp4Containers = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+}
+
+// probeMP4 reads the movie header for the duration and the first audio sample
+// description for the codec, channels and sample rate
+func probeMP4(r io.ReadSeeker, start, size int64, info *Info) error {
+	if err := walkAtoms(r, start, size, 0, info); err != nil {
+		return err
+	}
+
+	if info.Codec == "" {
+		return ErrUnknownFormat
+	}
+
+	return nil
+}
+
+// walkAtoms visits the atoms between offset and end
+func walkAtoms(r io.ReadSeeker, offset, end int64, depth int, info *Info) error {
+	if depth > maxAtomDepth {
+		return ErrUnknownFormat
+	}
+
+	for offset+8 <= end {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return ErrUnknownFormat
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		kind := string(header[4:8])
+		headerLength := int64(8)
+
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			large, err := readAt(r, offset+8, 8)
+			if err != nil {
+				return ErrUnknownFormat
+			}
+
+			size = int64(binary.BigEndian.Uint64(large))
+			headerLength = 16
+		}
+
+		if size < headerLength || offset+size > end {
+			return ErrUnknownFormat
+		}
+
+		body := offset + headerLength
+		switch {
+		case mp4Containers[kind]:
+			if err := walkAtoms(r, body, offset+size, depth+1, info); err != nil {
+				return err
+			}
+		case kind == "mvhd":
+			readMovieHeader(r, body, info)
+		case kind == "stsd" && info.Codec == "":
+			readSampleDescription(r, body, info)
+		}
+
+		offset += size
+	}
+
+	return nil
+}
+
+// readMovieHeader reads the duration of the whole movie
+func readMovieHeader(r io.ReadSeeker, body int64, info *Info) {
+	mvhd, err := readAt(r, body, 32)
+	if err != nil {
+		return
+	}
+
+	var timescale, duration int64
+	if mvhd[0] == 1 {
+		timescale = int64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = int64(binary.BigEndian.Uint64(mvhd[24:32]))
+	} else {
+		timescale = int64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = int64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+
+	info.Duration = seconds(duration, timescale)
+}
+
+// readSampleDescription reads the first sample entry if it describes audio
+func readSampleDescription(r io.ReadSeeker, body int64, info *Info) {
+	// version and flags, entry count, then the entry's size and type
+	stsd, err := readAt(r, body, 8+8+28)
+	if err != nil {
+		return
+	}
+
+	codec, ok := mp4Codecs[string(stsd[12:16])]
+	if !ok {
+		return
+	}
+
+	entry := stsd[16:]
+	info.Codec = codec
+	info.Channels = int(binary.BigEndian.Uint16(entry[16:18]))
+	// The sample rate is a 16.16 fixed point number
+	info.SampleRate = int(binary.BigEndian.Uint32(entry[24:28]) >> 16)
+}
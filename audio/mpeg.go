@@ -0,0 +1,202 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// maxSyncSearch is how much padding after an ID3v2 tag Probe skips looking for the first MPEG frame
+const maxSyncSearch = 64 * 1024
+
+// MPEG audio versions as encoded in the frame header
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+)
+
+// bitrates in kbps indexed by [version is MPEG-1][layer bits][bitrate index],
+// where layer bits 1, 2 and 3 are layers III, II and I
+var bitrates = [2][4][16]int{
+	{ // MPEG-2 and 2.5
+		{},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	},
+	{ // MPEG-1
+		{},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	},
+}
+
+// sampleRates in Hz indexed by [version][sample rate index]
+var sampleRates = [4][3]int{
+	mpeg25: {11025, 12000, 8000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg1:  {44100, 48000, 32000},
+}
+
+// frameHeader is a decoded MPEG audio frame header
+type frameHeader struct {
+	version    int
+	layer      int // 1, 2 or 3
+	bitrate    int // bits per second
+	sampleRate int
+	padding    int
+	mono       bool
+}
+
+// parseFrameHeader decodes the 4 byte header at the start of an MPEG audio frame
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return frameHeader{}, false
+	}
+
+	version := int(b[1] >> 3 & 0x03)
+	layerBits := int(b[1] >> 1 & 0x03)
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int(b[2] >> 2 & 0x03)
+
+	if version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return frameHeader{}, false
+	}
+
+	v1 := 0
+	if version == mpeg1 {
+		v1 = 1
+	}
+
+	return frameHeader{
+		version:    version,
+		layer:      4 - layerBits,
+		bitrate:    bitrates[v1][layerBits][bitrateIndex] * 1000,
+		sampleRate: sampleRates[version][rateIndex],
+		padding:    int(b[2] >> 1 & 0x01),
+		mono:       b[3]>>6 == 3,
+	}, true
+}
+
+// samplesPerFrame returns how many samples each channel has in one frame
+func (h frameHeader) samplesPerFrame() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && h.version != mpeg1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// length returns the size of the frame in bytes including its header
+func (h frameHeader) length() int {
+	if h.layer == 1 {
+		return (12*h.bitrate/h.sampleRate + h.padding) * 4
+	}
+
+	return h.samplesPerFrame()/8*h.bitrate/h.sampleRate + h.padding
+}
+
+// sideInfoLength returns the size of the layer III side information after the header
+func (h frameHeader) sideInfoLength() int {
+	switch {
+	case h.version == mpeg1 && h.mono:
+		return 17
+	case h.version == mpeg1:
+		return 32
+	case h.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// probeMPEG reads the first MPEG audio frame and the duration from a Xing or VBRI header,
+// estimating it from the bitrate for constant bitrate files without one.
+// The frame must start the file, or follow an ID3v2 tag and its padding,
+// so files which merely contain sync bytes somewhere are not taken for audio.
+func probeMPEG(r io.ReadSeeker, start, size int64, info *Info) error {
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxSyncSearch)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ErrUnknownFormat
+	}
+	buf = buf[:n]
+
+	// Some taggers pad the ID3v2 tag with zeros it does not count in its size
+	i := 0
+	if start > 0 {
+		for i < len(buf) && buf[i] == 0 {
+			i++
+		}
+	}
+
+	if i+4 > len(buf) {
+		return ErrUnknownFormat
+	}
+
+	header, ok := parseFrameHeader(buf[i:])
+	if !ok {
+		return ErrUnknownFormat
+	}
+
+	// Require the next frame to follow so a lucky first four bytes are not mistaken for audio
+	next := i + header.length()
+	if next+4 <= len(buf) {
+		if _, ok := parseFrameHeader(buf[next:]); !ok {
+			return ErrUnknownFormat
+		}
+	}
+
+	// Constant bitrate durations leave out an ID3v1 tag at the end
+	end := size
+	if size >= 128 {
+		if tag, err := readAt(r, size-128, 3); err == nil && bytes.Equal(tag, []byte("TAG")) {
+			end -= 128
+		}
+	}
+
+	describeMPEG(buf[i:], header, end-(start+int64(i)), info)
+	return nil
+}
+
+// describeMPEG fills info from the first frame of an MPEG audio stream of audioBytes
+func describeMPEG(frame []byte, header frameHeader, audioBytes int64, info *Info) {
+	info.Codec = [4]string{"", "mp1", "mp2", "mp3"}[header.layer]
+	info.SampleRate = header.sampleRate
+	info.Channels = 2
+	if header.mono {
+		info.Channels = 1
+	}
+
+	samplesPerFrame := int64(header.samplesPerFrame())
+
+	// Variable bitrate files record the number of frames in the first frame
+	xing := 4 + header.sideInfoLength()
+	if len(frame) >= xing+12 && (bytes.Equal(frame[xing:xing+4], []byte("Xing")) || bytes.Equal(frame[xing:xing+4], []byte("Info"))) {
+		flags := binary.BigEndian.Uint32(frame[xing+4:])
+		if flags&0x01 != 0 {
+			frames := int64(binary.BigEndian.Uint32(frame[xing+8:]))
+			info.Duration = seconds(frames*samplesPerFrame, int64(header.sampleRate))
+			return
+		}
+	}
+
+	const vbri = 4 + 32
+	if len(frame) >= vbri+18 && bytes.Equal(frame[vbri:vbri+4], []byte("VBRI")) {
+		frames := int64(binary.BigEndian.Uint32(frame[vbri+14:]))
+		info.Duration = seconds(frames*samplesPerFrame, int64(header.sampleRate))
+		return
+	}
+
+	info.Bitrate = header.bitrate
+	info.Duration = seconds(audioBytes*8, int64(header.bitrate))
+}
@@ -0,0 +1,99 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// oggTailSearch is how much of the end of the file is searched for the last Ogg page
+const oggTailSearch = 64 * 1024
+
+// opusGranuleRate is the rate Opus granule positions count at regardless of the input sample rate
+const opusGranuleRate = 48000
+
+// probeOgg reads the identification header of the first logical stream and the
+// granule position of its last page
+func probeOgg(r io.ReadSeeker, start, size int64, info *Info) error {
+	page, err := readAt(r, start, 27)
+	if err != nil {
+		return ErrUnknownFormat
+	}
+
+	serial := binary.LittleEndian.Uint32(page[14:18])
+	segments, err := readAt(r, start+27, int(page[26]))
+	if err != nil {
+		return ErrUnknownFormat
+	}
+
+	packetLength := 0
+	for _, segment := range segments {
+		packetLength += int(segment)
+		if segment < 255 {
+			break
+		}
+	}
+
+	packet, err := readAt(r, start+27+int64(len(segments)), packetLength)
+	if err != nil {
+		return ErrUnknownFormat
+	}
+
+	var granuleRate, preSkip int64
+
+	switch {
+	case len(packet) >= 28 && bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		info.Codec = "vorbis"
+		info.Channels = int(packet[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		info.Bitrate = int(int32(binary.LittleEndian.Uint32(packet[20:24])))
+		if info.Bitrate < 0 {
+			info.Bitrate = 0
+		}
+		granuleRate = int64(info.SampleRate)
+	case len(packet) >= 16 && bytes.HasPrefix(packet, []byte("OpusHead")):
+		info.Codec = "opus"
+		info.Channels = int(packet[9])
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		if info.SampleRate == 0 {
+			info.SampleRate = opusGranuleRate
+		}
+		granuleRate = opusGranuleRate
+	default:
+		return ErrUnknownFormat
+	}
+
+	granule, ok := lastGranule(r, size, serial)
+	if ok && granule > preSkip {
+		info.Duration = seconds(granule-preSkip, granuleRate)
+	}
+
+	return nil
+}
+
+// lastGranule returns the granule position of the last page of stream serial
+func lastGranule(r io.ReadSeeker, size int64, serial uint32) (int64, bool) {
+	offset := size - oggTailSearch
+	if offset < 0 {
+		offset = 0
+	}
+
+	tail, err := readAt(r, offset, int(size-offset))
+	if err != nil {
+		return 0, false
+	}
+
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if i+27 > len(tail) || binary.LittleEndian.Uint32(tail[i+14:i+18]) != serial {
+			continue
+		}
+
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+		if granule >= 0 {
+			return granule, true
+		}
+	}
+
+	return 0, false
+}
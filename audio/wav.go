@@ -0,0 +1,65 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// waveFormats maps the format tags of WAV files to codec names
+var waveFormats = map[uint16]string{
+	0x0001: "pcm",
+	0x0003: "pcm",
+	0xfffe: "pcm", // WAVE_FORMAT_EXTENSIBLE
+}
+
+// probeWAV reads the fmt chunk for the stream properties and the size of the data chunk for the duration
+func probeWAV(r io.ReadSeeker, start, size int64, info *Info) error {
+	var byteRate int64
+
+	offset := start + 12
+	for offset+8 <= size {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return ErrUnknownFormat
+		}
+
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+		body := offset + 8
+
+		switch string(header[0:4]) {
+		case "fmt ":
+			format, err := readAt(r, body, 16)
+			if err != nil {
+				return ErrUnknownFormat
+			}
+
+			codec, ok := waveFormats[binary.LittleEndian.Uint16(format[0:2])]
+			if !ok {
+				return ErrUnknownFormat
+			}
+
+			info.Codec = codec
+			info.Channels = int(binary.LittleEndian.Uint16(format[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(format[4:8]))
+			byteRate = int64(binary.LittleEndian.Uint32(format[8:12]))
+			info.Bitrate = int(byteRate * 8)
+		case "data":
+			if info.Codec == "" {
+				return ErrUnknownFormat
+			}
+
+			// Streamed files may not know the data size, so stop at the end of the file
+			if body+chunkSize > size {
+				chunkSize = size - body
+			}
+
+			info.Duration = seconds(chunkSize, byteRate)
+			return nil
+		}
+
+		// Chunks are padded to an even length
+		offset = body + chunkSize + chunkSize%2
+	}
+
+	return ErrUnknownFormat
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// maxCommentLength is the longest comment text accepted
+const maxCommentLength = 2000
+
+// CommentWithMeta contains a comment, its author and its replies for rendering
+type CommentWithMeta struct {
+	Comment   db.Comment
+	Author    string
+	Created   string
+	Likes     int
+	CanDelete bool
+	Replies   []*CommentWithMeta
+
+	// CSRFToken is needed by the reply and delete forms
+	CSRFToken string
+	// ShareKey lets replies be posted on an unlisted song reached by its secret link
+	ShareKey string
+}
+
+// commentsWithMeta converts comment threads for song.tmpl.
+// Comments can be deleted by their author and by the song's artist.
+func (s *Server) commentsWithMeta(threads []*db.CommentThread, currentUserID, songOwnerID int, csrfToken, shareKey string) []*CommentWithMeta {
+	var comments []*CommentWithMeta
+
+	for _, thread := range threads {
+		comments = append(comments, &CommentWithMeta{
+			Comment:   thread.Comment,
+			Author:    thread.Author,
+			Created:   humanize.Time(time.Unix(int64(thread.Comment.Created), 0)),
+			Likes:     s.DB.RefLikeCount(thread.Comment.ID, db.CommentType),
+			CanDelete: currentUserID != 0 && (currentUserID == thread.Comment.UserID || currentUserID == songOwnerID),
+			Replies:   s.commentsWithMeta(thread.Replies, currentUserID, songOwnerID, csrfToken, shareKey),
+			CSRFToken: csrfToken,
+			ShareKey:  shareKey,
+		})
+	}
+
+	return comments
+}
+
+// PostComment adds a comment or a reply to a song
+func (s *Server) PostComment(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	songID, err := strconv.Atoi(c.PostForm("songID"))
+	if err != nil {
+		c.String(http.StatusUnprocessableEntity, "Invalid song")
+		return
+	}
+
+	var replyTo int
+	if c.PostForm("replyTo") != "" {
+		replyTo, err = strconv.Atoi(c.PostForm("replyTo"))
+		if err != nil {
+			c.String(http.StatusUnprocessableEntity, "Invalid comment")
+			return
+		}
+	}
+
+	song, _, err := s.addComment(user, songID, replyTo, c.PostForm("text"), shareKey(c))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	s.redirectToSong(c, song)
+}
+
+// DeleteComment deletes a comment and its replies
+func (s *Server) DeleteComment(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	commentID, err := strconv.Atoi(c.PostForm("commentID"))
+	if err != nil {
+		c.String(http.StatusUnprocessableEntity, "Invalid comment")
+		return
+	}
+
+	song, err := s.deleteComment(user, commentID)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	s.redirectToSong(c, song)
+}
+
+// addComment validates and stores a comment by user on a song they can see with key.
+// Replies must be to a comment on the same song.
+func (s *Server) addComment(user db.User, songID, replyTo int, text, key string) (db.Song, int64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || len(text) > maxCommentLength {
+		return db.Song{}, 0, newAPIError(http.StatusUnprocessableEntity, fmt.Sprintf("Comments must be between 1 and %d characters", maxCommentLength))
+	}
+
+	song, err := s.DB.GetSong(songID)
+	if err == sql.ErrNoRows || (err == nil && !canView(user.ID, song, key)) {
+		return db.Song{}, 0, newAPIError(http.StatusNotFound, "Song not found")
+	}
+	if err != nil {
+		return db.Song{}, 0, err
+	}
+
+	if replyTo != 0 {
+		parent, err := s.DB.GetComment(replyTo)
+		if err != nil || parent.SongID != song.ID {
+			return db.Song{}, 0, newAPIError(http.StatusUnprocessableEntity, "Invalid comment to reply to")
+		}
+	}
+
+	id, err := s.DB.AddComment(text, user.ID, replyTo, song.ID)
+	return song, id, err
+}
+
+// deleteComment deletes a comment and its replies.
+// Only the comment author or the song's artist may delete it.
+func (s *Server) deleteComment(user db.User, commentID int) (db.Song, error) {
+	comment, err := s.DB.GetComment(commentID)
+	if err == sql.ErrNoRows {
+		return db.Song{}, errAPICommentNotFound
+	}
+	if err != nil {
+		return db.Song{}, err
+	}
+
+	song, err := s.DB.GetSong(comment.SongID)
+	if err != nil {
+		return db.Song{}, err
+	}
+
+	if comment.UserID != user.ID && song.UserID != user.ID {
+		return db.Song{}, newAPIError(http.StatusForbidden, "You cannot delete this comment")
+	}
+
+	return song, s.DB.DeleteComment(comment.ID)
+}
+
+// redirectToSong sends the browser back to a song's page
+func (s *Server) redirectToSong(c *gin.Context, song db.Song) {
+	artist, err := s.DB.GetUserByID(song.UserID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, withShareKey(c, song, songURL(artist.Username, song)))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// csrfSessionKey is where the session's CSRF token is kept
+	csrfSessionKey = "csrf"
+	// csrfFormField is the hidden form input templates submit the token in
+	csrfFormField = "csrfToken"
+	// csrfHeader is where scripts send the token instead of a form field
+	csrfHeader = "X-CSRF-Token"
+)
+
+var errCSRF = newAPIError(http.StatusForbidden, "Invalid or missing CSRF token")
+
+// guestFormTemplates are the pages with forms for visitors who are not logged in.
+// Other pages only give logged in users a CSRF token, so guests and crawlers browsing the site are not each stored a session.
+var guestFormTemplates = map[string]bool{
+	"login.tmpl":     true,
+	"register.tmpl":  true,
+	"forgot.tmpl":    true,
+	"reset.tmpl":     true,
+	"twofactor.tmpl": true,
+}
+
+// csrfTokenFrom returns the CSRF token for the current session, creating one if needed
+func csrfTokenFrom(c *gin.Context) string {
+	session := sessions.Default(c)
+
+	if token, ok := session.Get(csrfSessionKey).(string); ok && token != "" {
+		return token
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(random)
+	session.Set(csrfSessionKey, token)
+	if err := session.Save(); err != nil {
+		log.Printf("Failed to save CSRF token: %s\n", err)
+	}
+
+	return token
+}
+
+// pageCSRFToken returns the CSRF token for the forms of the page rendered from the template name.
+// Guests only get one on pages in guestFormTemplates, elsewhere it is "" unless they already have one.
+func pageCSRFToken(c *gin.Context, name string) string {
+	session := sessions.Default(c)
+
+	if token, ok := session.Get(csrfSessionKey).(string); ok && token != "" {
+		return token
+	}
+
+	if _, err := getCurrentUserFrom(session); err != nil && !guestFormTemplates[name] {
+		return ""
+	}
+
+	return csrfTokenFrom(c)
+}
+
+// renderHTML renders a template with the CSRF token its forms need
+func renderHTML(c *gin.Context, code int, name string, vars gin.H) {
+	vars["csrfToken"] = pageCSRFToken(c, name)
+	c.HTML(code, name, vars)
+}
+
+// CSRFProtection is a handler that rejects state changing requests which do not carry the session's CSRF token.
+// Requests authenticated with an API token in the Authorization header are exempt since browsers never send it cross-site.
+func CSRFProtection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+			c.Next()
+			return
+		}
+
+		expected, _ := sessions.Default(c).Get(csrfSessionKey).(string)
+
+		token := c.GetHeader(csrfHeader)
+		if token == "" {
+			token = c.PostForm(csrfFormField)
+		}
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			if isAPIRequest(c) {
+				apiAbort(c, errCSRF)
+				return
+			}
+
+			c.String(http.StatusForbidden, errCSRF.Error())
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotInAlbum is returned when reordering an album with a song which is not one of its tracks
+var ErrNotInAlbum = errors.New("the song is not a track of the album")
+
+// Album struct matches row on `albums` table
+type Album struct {
+	ID          int
+	UserID      int
+	PublicID    string
+	Title       string
+	Description string
+	// ReleaseDate is midnight UTC of the day the album came out
+	ReleaseDate int64
+	// CoverID is the public ID of the album's cover image, or "" if it has none
+	CoverID string
+	Created int
+	Updated int
+}
+
+// AlbumTrack is a song on an album
+type AlbumTrack struct {
+	Song
+	Position int
+}
+
+// albumColumns are selected by every query which scans an Album
+const albumColumns = "albums.id, albums.user_id, albums.public_id, albums.title, albums.description, albums.release_date, albums.cover_id, albums.created, albums.updated"
+
+// scanAlbum reads a row selected with albumColumns
+func scanAlbum(row scanner, extra ...interface{}) (album Album, err error) {
+	dest := []interface{}{&album.ID, &album.UserID, &album.PublicID, &album.Title, &album.Description, &album.ReleaseDate,
+		&album.CoverID, &album.Created, &album.Updated}
+	err = row.Scan(append(dest, extra...)...)
+	return album, err
+}
+
+// AddAlbum creates an album without tracks owned by a user. coverID may be "" if the album has no cover image.
+func (db *DB) AddAlbum(userID int, title, description string, releaseDate int64, coverID string) (int64, error) {
+	defer db.locked()()
+
+	publicID, err := newPublicID()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	res, err := db.DB.Exec("INSERT INTO albums (user_id, public_id, title, description, release_date, cover_id, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?);",
+		userID, publicID, title, description, releaseDate, coverID, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// GetAlbum returns an album by id
+func (db *DB) GetAlbum(id int) (Album, error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+albumColumns+" FROM albums WHERE id=?;", id)
+	return scanAlbum(row)
+}
+
+// GetAlbumByPublicID returns an album by the ID used in its links
+func (db *DB) GetAlbumByPublicID(publicID string) (Album, error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+albumColumns+" FROM albums WHERE public_id=?;", publicID)
+	return scanAlbum(row)
+}
+
+// GetAlbumsForUser returns the albums a user released, newest first
+func (db *DB) GetAlbumsForUser(userID int) (albums []Album, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT "+albumColumns+" FROM albums WHERE user_id=? ORDER BY release_date DESC, id DESC;", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		albums = append(albums, album)
+	}
+
+	return albums, rows.Err()
+}
+
+// GetSongAlbum returns the album a song is a track of and its position on it.
+// sql.ErrNoRows is returned for songs which are not on an album.
+func (db *DB) GetSongAlbum(songID int) (album Album, position int, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+albumColumns+", album_tracks.position FROM album_tracks INNER JOIN albums ON albums.id = album_tracks.album_id "+
+		"WHERE album_tracks.song_id=?;", songID)
+	album, err = scanAlbum(row, &position)
+	return album, position, err
+}
+
+// UpdateAlbum changes an album's title, description and release date
+func (db *DB) UpdateAlbum(albumID int, title, description string, releaseDate int64) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE albums SET title=?, description=?, release_date=?, updated=? WHERE id=?;",
+		title, description, releaseDate, time.Now().Unix(), albumID)
+	return err
+}
+
+// SetAlbumCover replaces the cover image of an album, "" removes it
+func (db *DB) SetAlbumCover(albumID int, coverID string) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE albums SET cover_id=?, updated=? WHERE id=?;", coverID, time.Now().Unix(), albumID)
+	return err
+}
+
+// DeleteAlbum deletes an album. Its tracks are kept as songs on their own.
+func (db *DB) DeleteAlbum(albumID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM album_tracks WHERE album_id=?;", albumID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM albums WHERE id=?;", albumID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAlbumTracks returns the tracks of an album in order
+func (db *DB) GetAlbumTracks(albumID int) (tracks []AlbumTrack, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT "+songColumns+", album_tracks.position FROM album_tracks INNER JOIN songs ON songs.id = album_tracks.song_id "+
+		"WHERE album_tracks.album_id=? ORDER BY album_tracks.position;", albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var track AlbumTrack
+
+		track.Song, err = scanSong(rows, &track.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+// AddAlbumTrack adds a song to the end of an album, taking it off any other album it was on
+func (db *DB) AddAlbumTrack(albumID, songID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM album_tracks WHERE song_id=?;", songID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO album_tracks (song_id, album_id, position) SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM album_tracks WHERE album_id=?;",
+		songID, albumID, albumID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE albums SET updated=? WHERE id=?;", time.Now().Unix(), albumID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveAlbumTrack takes a song off an album, keeping it as a song on its own
+func (db *DB) RemoveAlbumTrack(albumID, songID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM album_tracks WHERE album_id=? AND song_id=?;", albumID, songID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE albums SET updated=? WHERE id=?;", time.Now().Unix(), albumID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReorderAlbum puts the tracks of an album in the order of songIDs.
+// Tracks left out of songIDs keep their order after the ones given.
+func (db *DB) ReorderAlbum(albumID int, songIDs []int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := reorderSongs(tx, "album_tracks", "album_id", albumID, songIDs, ErrNotInAlbum); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE albums SET updated=? WHERE id=?;", time.Now().Unix(), albumID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -31,6 +31,12 @@ type User struct {
 	Email    string
 	Username string
 	Hash     []byte
+	Verified bool
+	// AvatarID is the public ID of the user's profile picture, or "" to use their Gravatar
+	AvatarID string
+
+	// SessionGeneration is increased to log the user out of every session
+	SessionGeneration int
 }
 
 // Song struct matches row on `songs` table
@@ -41,6 +47,104 @@ type Song struct {
 	Created     int
 	UserID      int
 	Filename    string
+	Size        int64 // bytes
+	PublicID    string
+	Slug        string
+	License     string
+	SongMeta
+	SongAccess
+
+	// ShareToken is the key in the secret link to an unlisted song
+	ShareToken string
+
+	// CurrentVersion is the ID of the SongVersion whose audio is played
+	CurrentVersion int
+	VersionsPublic bool
+
+	// CoverID is the public ID of the song's artwork, or "" if it has none
+	CoverID string
+}
+
+// Song visibilities
+const (
+	VisibilityPublic   = "public"   // listed everywhere once released
+	VisibilityUnlisted = "unlisted" // only reachable with the song's secret link
+	VisibilityPrivate  = "private"  // only seen by the artist
+)
+
+// SongAccess controls who can find and hear a song
+type SongAccess struct {
+	Visibility string
+	// ReleaseAt is when the song becomes visible to anyone but the artist, 0 for as soon as it is uploaded
+	ReleaseAt int64
+}
+
+// Released reports whether the song's release time has passed
+func (a SongAccess) Released(now time.Time) bool {
+	return a.ReleaseAt <= now.Unix()
+}
+
+// Listed reports whether the song shows up in listings for everyone
+func (a SongAccess) Listed(now time.Time) bool {
+	return a.Visibility == VisibilityPublic && a.Released(now)
+}
+
+// Published is when the song appeared for everyone, its release time if it was scheduled
+func (s Song) Published() int64 {
+	if s.ReleaseAt > int64(s.Created) {
+		return s.ReleaseAt
+	}
+
+	return int64(s.Created)
+}
+
+// songPublished is the SQL for Song.Published
+const songPublished = "MAX(songs.created, songs.release_at)"
+
+// listedSongs is the condition for songs listed for everyone, taking the current unix time.
+// Scheduled songs are listed as soon as their release time passes.
+const listedSongs = "songs.visibility='public' AND songs.release_at<=?"
+
+// SongMeta is read from the audio file when a song is uploaded
+type SongMeta struct {
+	Duration   float64 // seconds
+	Bitrate    int     // bits per second
+	SampleRate int
+	Channels   int
+	Codec      string
+
+	// Tags embedded in the file
+	Artist string
+	Album  string
+	Track  int
+}
+
+// Length formats Duration as minutes and seconds
+func (m SongMeta) Length() string {
+	seconds := int(m.Duration + 0.5)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Kbps returns the bitrate in kilobits per second
+func (m SongMeta) Kbps() int {
+	return (m.Bitrate + 500) / 1000
+}
+
+// songColumns are selected by every query which scans a Song
+const songColumns = "songs.id, songs.title, songs.description, songs.created, songs.user_id, songs.filename, songs.size, songs.public_id, songs.slug, songs.license, songs.duration, songs.bitrate, songs.sample_rate, songs.channels, songs.codec, songs.artist, songs.album, songs.track, songs.current_version, songs.versions_public, songs.visibility, songs.release_at, songs.share_token, songs.cover_id"
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSong reads a row selected with songColumns followed by any extra columns
+func scanSong(row scanner, extra ...interface{}) (song Song, err error) {
+	dest := []interface{}{&song.ID, &song.Title, &song.Description, &song.Created, &song.UserID, &song.Filename, &song.Size, &song.PublicID, &song.Slug, &song.License,
+		&song.Duration, &song.Bitrate, &song.SampleRate, &song.Channels, &song.Codec, &song.Artist, &song.Album, &song.Track, &song.CurrentVersion, &song.VersionsPublic,
+		&song.Visibility, &song.ReleaseAt, &song.ShareToken, &song.CoverID}
+	err = row.Scan(append(dest, extra...)...)
+	return song, err
 }
 
 // Comment struct matches row on `comments` table
@@ -53,15 +157,23 @@ type Comment struct {
 	SongID    int
 }
 
+// CommentThread is a comment with its author and nested replies
+type CommentThread struct {
+	Comment Comment
+	Author  string
+	Replies []*CommentThread
+}
+
 // RecentlyLikedSong Custom struct containing meta about recently popular songs
 type RecentlyLikedSong struct {
 	SongID int
 	Title  string
+	Slug   string
 	Artist string
 	Likes  int
 }
 
-// Open the database by path and setup tables
+// Open the database by path and migrate tables to the latest schema version
 func Open(ctx context.Context, DBPath string) (*DB, error) {
 	if err := os.MkdirAll(filepath.Dir(DBPath), 0700); err != nil {
 		return nil, err
@@ -87,78 +199,166 @@ func Open(ctx context.Context, DBPath string) (*DB, error) {
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS `users` (`id` INTEGER PRIMARY KEY, `created` INTEGER, `email` TEXT UNIQUE, `hash` BLOB, `username` TEXT UNIQUE);")
+	// Bring the schema up to date, failing if the database is newer than this binary
+	err = migrate(tx)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS `songs` (`id` INTEGER PRIMARY KEY, `title` TEXT, `description` TEXT, `created` INTEGER, `user_id` INTEGER, `filename` TEXT);")
+	err = backfillSongSlugs(tx)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS `comments` (`id` INTEGER PRIMARY KEY, `text` TEXT, `created` INTEGER, `user_id` INTEGER, `comment_id` INTEGER, `song_id` INTEGER);")
+	err = tx.Commit()
 	if err != nil {
 		return nil, err
 	}
 
-	// like table keeps track of likes for comments, accounts, and songs (ref_id)
-	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS `likes` (`id` INTEGER PRIMARY KEY, `created` INTEGER, `user_id` INTEGER, `ref_id` INTEGER, `type` INTEGER);")
+	db := &DB{
+		DB: sqlite,
+	}
+
+	return db, nil
+}
+
+// AddSong to the database with file as its first version
+func (db *DB) AddSong(title, description, license string, access SongAccess, userID int, file SongFile) (int64, error) {
+	defer db.locked()()
+
+	publicID, err := newPublicID()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_songs_created ON songs (created);")
+	shareToken, err := newShareToken()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_songs_user_id ON songs (user_id);")
+	tx, err := db.DB.Begin()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	err = tx.Commit()
+	slug, err := uniqueSlug(tx, userID, 0, title)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	db := &DB{
-		DB: sqlite,
+	created := time.Now().Unix()
+	res, err := tx.Exec("INSERT INTO songs (title, description, license, visibility, release_at, share_token, created, user_id, public_id, slug) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		title, description, license, access.Visibility, access.ReleaseAt, shareToken, created, userID, publicID, slug)
+	if err != nil {
+		return 0, err
 	}
 
-	return db, nil
-}
+	songID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
 
-// AddSong to the database
-func (db *DB) AddSong(title, description, filename string, userID int) error {
-	defer db.locked()()
+	if _, err := addSongVersion(tx, int(songID), file, ""); err != nil {
+		return 0, err
+	}
 
-	created := time.Now().Unix()
-	_, err := db.DB.Exec("INSERT INTO songs (title, description, created, user_id, filename) VALUES (?, ?, ?, ?, ?)", title, description, created, userID, filename)
-	return err
+	return songID, tx.Commit()
 }
 
 // GetSong returns a song by id
 func (db *DB) GetSong(id int) (result Song, err error) {
 	defer db.locked()()
 
-	row := db.DB.QueryRow("SELECT * FROM songs WHERE id=? LIMIT 1;", id)
-	err = row.Scan(&result.ID, &result.Title, &result.Description, &result.Created, &result.UserID, &result.Filename)
-	return result, err
+	row := db.DB.QueryRow("SELECT "+songColumns+" FROM songs WHERE id=? LIMIT 1;", id)
+	return scanSong(row)
 }
 
-// GetSongByNameForUser returns a song by title + user's id
+// GetSongByNameForUser returns a song by title + user's id.
+// Titles are not unique, so the first song uploaded with the title is returned.
 func (db *DB) GetSongByNameForUser(title string, userID int) (result Song, err error) {
 	defer db.locked()()
 
-	row := db.DB.QueryRow("SELECT * FROM songs WHERE title=? AND user_id=?;", title, userID)
-	err = row.Scan(&result.ID, &result.Title, &result.Description, &result.Created, &result.UserID, &result.Filename)
-	return result, err
+	row := db.DB.QueryRow("SELECT "+songColumns+" FROM songs WHERE title=? AND user_id=? ORDER BY id LIMIT 1;", title, userID)
+	return scanSong(row)
+}
+
+// SongEdit is every change made to a song from its edit page
+type SongEdit struct {
+	Title          string
+	Description    string
+	License        string
+	Access         SongAccess
+	VersionsPublic bool
+
+	// Version becomes the current version with VersionNotes unless it is nil
+	Version      *SongFile
+	VersionNotes string
+
+	// Cover is the object key and size of a new cover image, "" keeps the current one unless RemoveCover is set
+	Cover       string
+	CoverSize   int64
+	RemoveCover bool
+}
+
+// EditSong applies an edit to a song all at once. Likes, comments and the song's public ID are kept.
+// The cover image the edit replaced or removed is returned so its objects can be deleted, or a zero Image if there was none.
+func (db *DB) EditSong(songID, userID int, edit SongEdit) (replaced Image, err error) {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return Image{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := retitleSong(tx, songID, edit.Title); err != nil {
+		return Image{}, err
+	}
+
+	if _, err := tx.Exec("UPDATE songs SET description=?, license=?, visibility=?, release_at=?, versions_public=? WHERE id=?;",
+		edit.Description, edit.License, edit.Access.Visibility, edit.Access.ReleaseAt, edit.VersionsPublic, songID); err != nil {
+		return Image{}, err
+	}
+
+	if edit.Version != nil {
+		if _, err := addSongVersion(tx, songID, *edit.Version, edit.VersionNotes); err != nil {
+			return Image{}, err
+		}
+	}
+
+	if edit.Cover != "" || edit.RemoveCover {
+		row := tx.QueryRow("SELECT images.id, images.user_id, images.public_id, images.original, images.size, images.created FROM songs "+
+			"INNER JOIN images ON images.public_id = songs.cover_id WHERE songs.id=?;", songID)
+		err := row.Scan(&replaced.ID, &replaced.UserID, &replaced.PublicID, &replaced.Original, &replaced.Size, &replaced.Created)
+		if err != nil && err != sql.ErrNoRows {
+			return Image{}, err
+		}
+
+		if err == nil {
+			if err := deleteImage(tx, replaced.ID); err != nil {
+				return Image{}, err
+			}
+		}
+	}
+
+	if edit.Cover != "" {
+		_, coverID, err := addImage(tx, userID, edit.Cover, edit.CoverSize)
+		if err != nil {
+			return Image{}, err
+		}
+
+		if _, err := tx.Exec("UPDATE songs SET cover_id=? WHERE id=?;", coverID, songID); err != nil {
+			return Image{}, err
+		}
+	}
+
+	return replaced, tx.Commit()
 }
 
 // DeleteSongByID from the database
-// Also deletes associated comments
+// Also deletes its likes and comments and removes it from playlists and its album.
+// sql.ErrNoRows is returned if the user has no song with that id.
 func (db *DB) DeleteSongByID(userID int, songID int) error {
 	defer db.locked()()
 
@@ -168,26 +368,147 @@ func (db *DB) DeleteSongByID(userID int, songID int) error {
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	_, err = tx.Exec(`DELETE FROM songs WHERE id=? AND user_id=?`, songID, userID)
-	if err == sql.ErrNoRows {
-		err = nil
+	res, err := tx.Exec(`DELETE FROM songs WHERE id=? AND user_id=?`, songID, userID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	// Song ids are reused, so nothing may be left pointing at this one
+	_, err = tx.Exec(`DELETE FROM likes WHERE type=? AND ref_id=?`, SongType, songID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM likes WHERE type=? AND ref_id IN (SELECT id FROM comments WHERE song_id=?)`, CommentType, songID)
+	if err != nil {
+		return err
 	}
 
 	_, err = tx.Exec(`DELETE FROM comments WHERE song_id=?`, songID)
-	if err == sql.ErrNoRows {
-		err = nil
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM song_redirects WHERE song_id=?`, songID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM song_versions WHERE song_id=?`, songID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM playlist_songs WHERE song_id=?`, songID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM album_tracks WHERE song_id=?`, songID)
+	if err != nil {
+		return err
 	}
 
 	return tx.Commit()
 }
 
 // AddComment to the database
-func (db *DB) AddComment(text string, userID, commentID, songID int) error {
+func (db *DB) AddComment(text string, userID, commentID, songID int) (int64, error) {
 	defer db.locked()()
 
 	created := time.Now().Unix()
-	_, err := db.DB.Exec("INSERT INTO comments (text, created, user_id, comment_id, song_id) VALUES (?, ?, ?, ?, ?)", text, created, userID, commentID, songID)
-	return err
+	res, err := db.DB.Exec("INSERT INTO comments (text, created, user_id, comment_id, song_id) VALUES (?, ?, ?, ?, ?)", text, created, userID, commentID, songID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// GetComment returns a comment by id
+func (db *DB) GetComment(id int) (result Comment, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT id, text, created, user_id, comment_id, song_id FROM comments WHERE id=? LIMIT 1;", id)
+	err = row.Scan(&result.ID, &result.Text, &result.Created, &result.UserID, &result.CommentID, &result.SongID)
+	return result, err
+}
+
+// GetCommentsForSong returns the comments on a song as threads of replies, oldest first
+func (db *DB) GetCommentsForSong(songID int) (threads []*CommentThread, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT comments.id, comments.text, comments.created, comments.user_id, comments.comment_id, comments.song_id, COALESCE(users.username, '') FROM comments LEFT JOIN users ON users.id = comments.user_id WHERE comments.song_id=? ORDER BY comments.created ASC, comments.id ASC;", songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]*CommentThread)
+
+	for rows.Next() {
+		thread := &CommentThread{}
+		comment := &thread.Comment
+
+		if err := rows.Scan(&comment.ID, &comment.Text, &comment.Created, &comment.UserID, &comment.CommentID, &comment.SongID, &thread.Author); err != nil {
+			return nil, err
+		}
+
+		byID[comment.ID] = thread
+
+		// Replies are always newer than the comment they reply to
+		if parent, ok := byID[comment.CommentID]; ok && comment.CommentID != 0 {
+			parent.Replies = append(parent.Replies, thread)
+			continue
+		}
+
+		threads = append(threads, thread)
+	}
+
+	return threads, rows.Err()
+}
+
+// CommentCount Counts number of comments on a song
+func (db *DB) CommentCount(songID int) (count int) {
+	defer db.locked()()
+
+	rows := db.DB.QueryRow("SELECT count(*) FROM comments WHERE song_id=?", songID)
+	rows.Scan(&count)
+	return count
+}
+
+// DeleteComment from the database
+// Also deletes all replies to the comment and their likes
+func (db *DB) DeleteComment(commentID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	const thread = `WITH RECURSIVE thread(id) AS (SELECT ? UNION SELECT comments.id FROM comments INNER JOIN thread ON comments.comment_id = thread.id)`
+
+	_, err = tx.Exec(thread+` DELETE FROM likes WHERE type=? AND ref_id IN (SELECT id FROM thread)`, commentID, CommentType)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(thread+` DELETE FROM comments WHERE id IN (SELECT id FROM thread)`, commentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // AddUser to the database
@@ -213,28 +534,28 @@ func (db *DB) Like(userID, refID, likeType int) error {
 }
 
 // Remove a Like from the database
-func (db *DB) Dislike(userID, refID int) error {
+func (db *DB) Dislike(userID, refID, likeType int) error {
 	defer db.locked()()
 
-	_, err := db.DB.Exec("DELETE FROM likes WHERE user_id=? AND ref_id=?", userID, refID)
+	_, err := db.DB.Exec("DELETE FROM likes WHERE user_id=? AND ref_id=? AND type=?", userID, refID, likeType)
 	return err
 }
 
-// RefLikeCount Counts number of likes for specific id
-func (db *DB) RefLikeCount(refID int) (count int) {
+// RefLikeCount Counts number of likes for specific id of a like type
+func (db *DB) RefLikeCount(refID, likeType int) (count int) {
 	defer db.locked()()
 
-	rows := db.DB.QueryRow("SELECT count(*) FROM likes WHERE ref_id=?", refID)
+	rows := db.DB.QueryRow("SELECT count(*) FROM likes WHERE ref_id=? AND type=?", refID, likeType)
 	rows.Scan(&count)
 	return count
 }
 
 // IsLiked Counts number of items liked by user
-func (db *DB) IsLiked(userID, refID int) bool {
+func (db *DB) IsLiked(userID, refID, likeType int) bool {
 	defer db.locked()()
 
 	var count int
-	rows := db.DB.QueryRow("SELECT count(*) FROM likes WHERE user_id=? AND ref_id=?", userID, refID)
+	rows := db.DB.QueryRow("SELECT count(*) FROM likes WHERE user_id=? AND ref_id=? AND type=?", userID, refID, likeType)
 	rows.Scan(&count)
 	return (count > 0)
 }
@@ -251,19 +572,65 @@ func (db *DB) UserLikeCount(userID int) (count int) {
 // DeleteUser from the database
 func (db *DB) DeleteUser(userID int) error {
 	defer db.locked()()
-	_, err := db.DB.Exec(`DELETE FROM users WHERE id=?`, userID)
-	if err == sql.ErrNoRows {
-		err = nil
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
 	}
-	return err
+	defer func() { _ = tx.Rollback() }()
+
+	var bucket string
+	err = tx.QueryRow(`SELECT username FROM users WHERE id=?`, userID).Scan(&bucket)
+	if err != nil {
+		return err
+	}
+
+	// Every comment the user wrote, on their songs, or replying to either
+	const doomed = `WITH RECURSIVE doomed(id) AS (
+		SELECT id FROM comments WHERE user_id=? OR song_id IN (SELECT id FROM songs WHERE user_id=?)
+		UNION SELECT comments.id FROM comments INNER JOIN doomed ON comments.comment_id = doomed.id)`
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{doomed + ` DELETE FROM likes WHERE type=? AND ref_id IN (SELECT id FROM doomed)`, []interface{}{userID, userID, CommentType}},
+		{doomed + ` DELETE FROM comments WHERE id IN (SELECT id FROM doomed)`, []interface{}{userID, userID}},
+		{`DELETE FROM likes WHERE type=? AND ref_id IN (SELECT id FROM songs WHERE user_id=?)`, []interface{}{SongType, userID}},
+		{`DELETE FROM likes WHERE (type=? AND ref_id=?) OR user_id=?`, []interface{}{UserType, userID, userID}},
+		{`DELETE FROM follows WHERE follower_id=? OR following_id=?`, []interface{}{userID, userID}},
+		{`DELETE FROM song_redirects WHERE user_id=?`, []interface{}{userID}},
+		{`DELETE FROM song_versions WHERE song_id IN (SELECT id FROM songs WHERE user_id=?)`, []interface{}{userID}},
+		{`DELETE FROM playlist_songs WHERE song_id IN (SELECT id FROM songs WHERE user_id=?) OR playlist_id IN (SELECT id FROM playlists WHERE user_id=?)`, []interface{}{userID, userID}},
+		{`DELETE FROM playlist_collaborators WHERE user_id=? OR playlist_id IN (SELECT id FROM playlists WHERE user_id=?)`, []interface{}{userID, userID}},
+		{`DELETE FROM playlists WHERE user_id=?`, []interface{}{userID}},
+		{`DELETE FROM album_tracks WHERE album_id IN (SELECT id FROM albums WHERE user_id=?)`, []interface{}{userID}},
+		{`DELETE FROM albums WHERE user_id=?`, []interface{}{userID}},
+		{`DELETE FROM images WHERE user_id=?`, []interface{}{userID}},
+		{`DELETE FROM songs WHERE user_id=?`, []interface{}{userID}},
+		{`DELETE FROM api_tokens WHERE user_id=?`, []interface{}{userID}},
+		{`DELETE FROM recovery_codes WHERE user_id=?`, []interface{}{userID}},
+		{`DELETE FROM users WHERE id=?`, []interface{}{userID}},
+		// The objects are removed from the bucket after the rows are gone
+		{`INSERT OR REPLACE INTO pending_purges (bucket, created, attempts, next_attempt, last_error) VALUES (?, ?, 0, 0, '')`, []interface{}{bucket, time.Now().Unix()}},
+	}
+
+	for _, statement := range statements {
+		_, err = tx.Exec(statement.query, statement.args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // GetUserByID checks if user exists in the database
 func (db *DB) GetUserByID(userID int) (result User, err error) {
 	defer db.locked()()
 
-	row := db.DB.QueryRow("SELECT id,created,email,username FROM users WHERE id=? LIMIT 1;", userID)
-	err = row.Scan(&result.ID, &result.Created, &result.Email, &result.Username)
+	row := db.DB.QueryRow("SELECT id,created,email,username,verified,session_generation,avatar_id FROM users WHERE id=? LIMIT 1;", userID)
+	err = row.Scan(&result.ID, &result.Created, &result.Email, &result.Username, &result.Verified, &result.SessionGeneration, &result.AvatarID)
 	return result, err
 }
 
@@ -271,25 +638,39 @@ func (db *DB) GetUserByID(userID int) (result User, err error) {
 func (db *DB) GetUserByName(user string) (result User, err error) {
 	defer db.locked()()
 
-	row := db.DB.QueryRow("SELECT id,created,email,username FROM users WHERE username=? LIMIT 1;", user)
-	err = row.Scan(&result.ID, &result.Created, &result.Email, &result.Username)
+	row := db.DB.QueryRow("SELECT id,created,email,username,verified,session_generation,avatar_id FROM users WHERE username=? LIMIT 1;", user)
+	err = row.Scan(&result.ID, &result.Created, &result.Email, &result.Username, &result.Verified, &result.SessionGeneration, &result.AvatarID)
+	return result, err
+}
+
+// GetUserByEmail checks if a user with the email address exists in the database
+func (db *DB) GetUserByEmail(email string) (result User, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT id,created,email,username,verified,session_generation,avatar_id FROM users WHERE email=? COLLATE NOCASE LIMIT 1;", email)
+	err = row.Scan(&result.ID, &result.Created, &result.Email, &result.Username, &result.Verified, &result.SessionGeneration, &result.AvatarID)
 	return result, err
 }
 
-// GetSongsForUser returns all songs for a specific user
-func (db *DB) GetSongsForUser(userID int) (songs []Song, err error) {
+// GetSongsForUser returns the songs of a specific user listed for everyone,
+// or all of them including private, unlisted and scheduled songs if hidden is set
+func (db *DB) GetSongsForUser(userID int, hidden bool) (songs []Song, err error) {
 	defer db.locked()()
 
-	rows, err := db.DB.Query("SELECT * FROM songs WHERE user_id=?;", userID)
+	var rows *sql.Rows
+	if hidden {
+		rows, err = db.DB.Query("SELECT "+songColumns+" FROM songs WHERE user_id=?;", userID)
+	} else {
+		rows, err = db.DB.Query("SELECT "+songColumns+" FROM songs WHERE user_id=? AND "+listedSongs+";", userID, time.Now().Unix())
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var song Song
-
-		if err := rows.Scan(&song.ID, &song.Title, &song.Description, &song.Created, &song.UserID, &song.Filename); err != nil {
+		song, err := scanSong(rows)
+		if err != nil {
 			return nil, err
 		}
 
@@ -299,20 +680,20 @@ func (db *DB) GetSongsForUser(userID int) (songs []Song, err error) {
 	return songs, err
 }
 
-// GetRecentSongs returns last 35 songs created
+// GetRecentSongs returns the last 35 songs released for everyone.
+// Scheduled songs are ordered by their release time rather than when they were uploaded.
 func (db *DB) GetRecentSongs() (songs []Song, err error) {
 	defer db.locked()()
 
-	rows, err := db.DB.Query("SELECT * FROM songs ORDER BY created DESC LIMIT 35")
+	rows, err := db.DB.Query("SELECT "+songColumns+" FROM songs WHERE "+listedSongs+" ORDER BY "+songPublished+" DESC LIMIT 35", time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var song Song
-
-		if err := rows.Scan(&song.ID, &song.Title, &song.Description, &song.Created, &song.UserID, &song.Filename); err != nil {
+		song, err := scanSong(rows)
+		if err != nil {
 			return nil, err
 		}
 
@@ -326,7 +707,7 @@ func (db *DB) GetRecentLikedSongs() (songs []RecentlyLikedSong, err error) {
 	defer db.locked()()
 
 	oneWeekAgo := time.Now().AddDate(0, 0, -7).Unix()
-	rows, err := db.DB.Query("SELECT likes.ref_id AS id, songs.title AS title, users.username AS username, COUNT(*) AS likes FROM likes INNER JOIN songs ON songs.id = likes.ref_id INNER JOIN users ON songs.user_id = users.id WHERE likes.created>? AND likes.type=1 GROUP BY likes.ref_id ORDER BY COUNT(*) DESC LIMIT 10", oneWeekAgo)
+	rows, err := db.DB.Query("SELECT likes.ref_id AS id, songs.title AS title, songs.slug AS slug, users.username AS username, COUNT(*) AS likes FROM likes INNER JOIN songs ON songs.id = likes.ref_id INNER JOIN users ON songs.user_id = users.id WHERE likes.created>? AND likes.type=1 AND "+listedSongs+" GROUP BY likes.ref_id ORDER BY COUNT(*) DESC LIMIT 10", oneWeekAgo, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -335,7 +716,7 @@ func (db *DB) GetRecentLikedSongs() (songs []RecentlyLikedSong, err error) {
 	for rows.Next() {
 		var song RecentlyLikedSong
 
-		if err := rows.Scan(&song.SongID, &song.Title, &song.Artist, &song.Likes); err != nil {
+		if err := rows.Scan(&song.SongID, &song.Title, &song.Slug, &song.Artist, &song.Likes); err != nil {
 			return nil, err
 		}
 
@@ -356,6 +737,30 @@ func (db *DB) GetUserHash(userID int) (hash []byte, err error) {
 	return hash, err
 }
 
+// SetUserVerified marks a user's email address as confirmed
+func (db *DB) SetUserVerified(userID int) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE users SET verified=1 WHERE id=?", userID)
+	return err
+}
+
+// SetUserHash replaces the password hash for a user
+func (db *DB) SetUserHash(userID int, hash []byte) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE users SET hash=? WHERE id=?", hash, userID)
+	return err
+}
+
+// ResetUserHash replaces the password hash for a user and ends all of their sessions
+func (db *DB) ResetUserHash(userID int, hash []byte) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE users SET hash=?, session_generation=session_generation+1 WHERE id=?", hash, userID)
+	return err
+}
+
 // Close the database
 func (db *DB) Close() error {
 	return db.DB.Close()
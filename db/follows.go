@@ -0,0 +1,100 @@
+package db
+
+import (
+	"math"
+	"time"
+)
+
+// FeedCursor marks where a page of the feed ended.
+// The next page starts with songs published before Published, or at the same time with a lower ID.
+type FeedCursor struct {
+	Published int64
+	SongID    int
+}
+
+// Follow makes follower see the songs of following in their feed
+func (db *DB) Follow(followerID, followingID int) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("INSERT OR IGNORE INTO follows (follower_id, following_id, created) VALUES (?, ?, ?);", followerID, followingID, time.Now().Unix())
+	return err
+}
+
+// Unfollow stops follower seeing the songs of following in their feed
+func (db *DB) Unfollow(followerID, followingID int) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("DELETE FROM follows WHERE follower_id=? AND following_id=?;", followerID, followingID)
+	return err
+}
+
+// IsFollowing reports whether follower follows following
+func (db *DB) IsFollowing(followerID, followingID int) bool {
+	defer db.locked()()
+
+	var count int
+	row := db.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE follower_id=? AND following_id=?;", followerID, followingID)
+	if err := row.Scan(&count); err != nil {
+		return false
+	}
+
+	return count > 0
+}
+
+// FollowerCount returns how many users follow a user
+func (db *DB) FollowerCount(userID int) (count int) {
+	defer db.locked()()
+
+	_ = db.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE following_id=?;", userID).Scan(&count)
+	return count
+}
+
+// FollowingCount returns how many users a user follows
+func (db *DB) FollowingCount(userID int) (count int) {
+	defer db.locked()()
+
+	_ = db.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE follower_id=?;", userID).Scan(&count)
+	return count
+}
+
+// GetFeed returns up to limit songs listed for everyone by the artists a user follows,
+// newest first, starting after before. A zero cursor starts with the newest song.
+// next is where the following page starts, or zero if there are no more songs.
+func (db *DB) GetFeed(userID int, before FeedCursor, limit int) (songs []Song, next FeedCursor, err error) {
+	defer db.locked()()
+
+	if before.Published == 0 {
+		before = FeedCursor{Published: math.MaxInt64}
+	}
+
+	// One extra song tells whether there is another page
+	rows, err := db.DB.Query("SELECT "+songColumns+" FROM songs INNER JOIN follows ON follows.following_id = songs.user_id "+
+		"WHERE follows.follower_id=? AND "+listedSongs+" AND ("+songPublished+"<? OR ("+songPublished+"=? AND songs.id<?)) "+
+		"ORDER BY "+songPublished+" DESC, songs.id DESC LIMIT ?;",
+		userID, time.Now().Unix(), before.Published, before.Published, before.SongID, limit+1)
+	if err != nil {
+		return nil, FeedCursor{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, FeedCursor{}, err
+		}
+
+		songs = append(songs, song)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, FeedCursor{}, err
+	}
+
+	if len(songs) > limit {
+		songs = songs[:limit]
+		last := songs[limit-1]
+		next = FeedCursor{Published: last.Published(), SongID: last.ID}
+	}
+
+	return songs, next, nil
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Image struct matches row on `images` table
+type Image struct {
+	ID       int
+	UserID   int
+	PublicID string
+	// Original is the object key of the uploaded file in the owner's bucket
+	Original string
+	// Size is the number of bytes stored for the original and its thumbnails
+	Size    int64
+	Created int
+}
+
+// AddImage records an image uploaded by a user
+func (db *DB) AddImage(userID int, original string, size int64) (int64, error) {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	id, _, err := addImage(tx, userID, original, size)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// addImage records an image inside tx and returns its id and public ID
+func addImage(tx *sql.Tx, userID int, original string, size int64) (int64, string, error) {
+	publicID, err := newPublicID()
+	if err != nil {
+		return 0, "", err
+	}
+
+	res, err := tx.Exec("INSERT INTO images (user_id, public_id, original, size, created) VALUES (?, ?, ?, ?, ?);",
+		userID, publicID, original, size, time.Now().Unix())
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, err := res.LastInsertId()
+	return id, publicID, err
+}
+
+// GetImage returns an image by id
+func (db *DB) GetImage(id int) (image Image, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT id, user_id, public_id, original, size, created FROM images WHERE id=?;", id)
+	err = row.Scan(&image.ID, &image.UserID, &image.PublicID, &image.Original, &image.Size, &image.Created)
+	return image, err
+}
+
+// GetImageByPublicID returns an image by the ID used in its links
+func (db *DB) GetImageByPublicID(publicID string) (image Image, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT id, user_id, public_id, original, size, created FROM images WHERE public_id=?;", publicID)
+	err = row.Scan(&image.ID, &image.UserID, &image.PublicID, &image.Original, &image.Size, &image.Created)
+	return image, err
+}
+
+// AddImageSize counts a thumbnail made after the image was uploaded towards its size
+func (db *DB) AddImageSize(imageID int, size int64) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE images SET size=size+? WHERE id=?;", size, imageID)
+	return err
+}
+
+// DeleteImage removes an image along with any avatar or cover it was used as
+func (db *DB) DeleteImage(imageID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := deleteImage(tx, imageID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteImage removes an image inside tx along with any avatar or cover it was used as
+func deleteImage(tx *sql.Tx, imageID int) error {
+	for _, statement := range []string{
+		"UPDATE users SET avatar_id='' WHERE avatar_id=(SELECT public_id FROM images WHERE id=?);",
+		"UPDATE songs SET cover_id='' WHERE cover_id=(SELECT public_id FROM images WHERE id=?);",
+		"UPDATE albums SET cover_id='' WHERE cover_id=(SELECT public_id FROM images WHERE id=?);",
+		"DELETE FROM images WHERE id=?;",
+	} {
+		if _, err := tx.Exec(statement, imageID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetAvatar replaces a user's profile picture, "" goes back to their Gravatar
+func (db *DB) SetAvatar(userID int, avatarID string) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE users SET avatar_id=? WHERE id=?;", avatarID, userID)
+	return err
+}
+
+// SetSongCover replaces the artwork of a song, "" removes it
+func (db *DB) SetSongCover(songID int, coverID string) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE songs SET cover_id=? WHERE id=?;", coverID, songID)
+	return err
+}
@@ -0,0 +1,337 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer binary
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// Migration is a single numbered change to the database schema
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// migrations must be kept in order of Version and never be edited once released.
+// Add new columns and tables by appending a migration to the end.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create users, songs, comments and likes tables",
+		Statements: []string{
+			"CREATE TABLE IF NOT EXISTS `users` (`id` INTEGER PRIMARY KEY, `created` INTEGER, `email` TEXT UNIQUE, `hash` BLOB, `username` TEXT UNIQUE);",
+			"CREATE TABLE IF NOT EXISTS `songs` (`id` INTEGER PRIMARY KEY, `title` TEXT, `description` TEXT, `created` INTEGER, `user_id` INTEGER, `filename` TEXT);",
+			"CREATE TABLE IF NOT EXISTS `comments` (`id` INTEGER PRIMARY KEY, `text` TEXT, `created` INTEGER, `user_id` INTEGER, `comment_id` INTEGER, `song_id` INTEGER);",
+			// like table keeps track of likes for comments, accounts, and songs (ref_id)
+			"CREATE TABLE IF NOT EXISTS `likes` (`id` INTEGER PRIMARY KEY, `created` INTEGER, `user_id` INTEGER, `ref_id` INTEGER, `type` INTEGER);",
+			"CREATE INDEX IF NOT EXISTS idx_songs_created ON songs (created);",
+			"CREATE INDEX IF NOT EXISTS idx_songs_user_id ON songs (user_id);",
+		},
+	},
+	{
+		Version:     2,
+		Description: "Index comments by song and likes by reference",
+		Statements: []string{
+			"CREATE INDEX IF NOT EXISTS idx_comments_song_id ON comments (song_id);",
+			"CREATE INDEX IF NOT EXISTS idx_likes_ref ON likes (ref_id, type);",
+		},
+	},
+	{
+		Version:     3,
+		Description: "Create api_tokens table",
+		Statements: []string{
+			"CREATE TABLE `api_tokens` (`id` INTEGER PRIMARY KEY, `created` INTEGER, `user_id` INTEGER, `name` TEXT, `hash` BLOB UNIQUE, `scopes` TEXT, `last_used` INTEGER);",
+			"CREATE INDEX idx_api_tokens_user_id ON api_tokens (user_id);",
+		},
+	},
+	{
+		Version:     4,
+		Description: "Add verified flag to users",
+		Statements: []string{
+			"ALTER TABLE `users` ADD COLUMN `verified` INTEGER NOT NULL DEFAULT 0;",
+			// accounts created before email verification existed are trusted
+			"UPDATE `users` SET `verified` = 1;",
+		},
+	},
+	{
+		Version:     5,
+		Description: "Add session generation to users",
+		Statements: []string{
+			"ALTER TABLE `users` ADD COLUMN `session_generation` INTEGER NOT NULL DEFAULT 0;",
+		},
+	},
+	{
+		Version:     6,
+		Description: "Add two-factor authentication",
+		Statements: []string{
+			"ALTER TABLE `users` ADD COLUMN `totp_secret` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `users` ADD COLUMN `totp_last_step` INTEGER NOT NULL DEFAULT 0;",
+			"CREATE TABLE `recovery_codes` (`id` INTEGER PRIMARY KEY, `user_id` INTEGER, `hash` BLOB);",
+			"CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);",
+		},
+	},
+	{
+		Version:     7,
+		Description: "Create pending_purges table",
+		Statements: []string{
+			// buckets of deleted accounts which still have to be removed from object storage
+			"CREATE TABLE `pending_purges` (`bucket` TEXT PRIMARY KEY, `created` INTEGER, `attempts` INTEGER, `next_attempt` INTEGER, `last_error` TEXT);",
+		},
+	},
+	{
+		Version:     8,
+		Description: "Add audio metadata to songs",
+		Statements: []string{
+			"ALTER TABLE `songs` ADD COLUMN `duration` REAL NOT NULL DEFAULT 0;",
+			"ALTER TABLE `songs` ADD COLUMN `bitrate` INTEGER NOT NULL DEFAULT 0;",
+			"ALTER TABLE `songs` ADD COLUMN `sample_rate` INTEGER NOT NULL DEFAULT 0;",
+			"ALTER TABLE `songs` ADD COLUMN `channels` INTEGER NOT NULL DEFAULT 0;",
+			"ALTER TABLE `songs` ADD COLUMN `codec` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `songs` ADD COLUMN `artist` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `songs` ADD COLUMN `album` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `songs` ADD COLUMN `track` INTEGER NOT NULL DEFAULT 0;",
+		},
+	},
+	{
+		Version:     9,
+		Description: "Track song sizes and user storage quotas",
+		Statements: []string{
+			"ALTER TABLE `songs` ADD COLUMN `size` INTEGER NOT NULL DEFAULT 0;",
+			// a quota of 0 uses the site wide default
+			"ALTER TABLE `users` ADD COLUMN `storage_quota` INTEGER NOT NULL DEFAULT 0;",
+		},
+	},
+	{
+		Version:     10,
+		Description: "Add public IDs, slugs and slug redirects to songs",
+		Statements: []string{
+			"ALTER TABLE `songs` ADD COLUMN `public_id` TEXT NOT NULL DEFAULT '';",
+			"UPDATE `songs` SET `public_id` = lower(hex(randomblob(8)));",
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_songs_public_id ON songs (public_id);",
+			// slugs of existing songs are filled in by Open
+			"ALTER TABLE `songs` ADD COLUMN `slug` TEXT NOT NULL DEFAULT '';",
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_songs_user_id_slug ON songs (user_id, slug) WHERE slug != '';",
+			// slugs songs had before their title changed
+			"CREATE TABLE `song_redirects` (`user_id` INTEGER, `slug` TEXT, `song_id` INTEGER, `created` INTEGER, PRIMARY KEY (`user_id`, `slug`));",
+			"CREATE INDEX IF NOT EXISTS idx_song_redirects_song_id ON song_redirects (song_id);",
+		},
+	},
+	{
+		Version:     11,
+		Description: "Create song_versions table",
+		Statements: []string{
+			// every audio file uploaded for a song, the current one is copied into the songs row
+			"CREATE TABLE `song_versions` (`id` INTEGER PRIMARY KEY, `song_id` INTEGER, `number` INTEGER, `created` INTEGER, `notes` TEXT NOT NULL DEFAULT '', `filename` TEXT, `size` INTEGER NOT NULL DEFAULT 0, `duration` REAL NOT NULL DEFAULT 0, `bitrate` INTEGER NOT NULL DEFAULT 0, `sample_rate` INTEGER NOT NULL DEFAULT 0, `channels` INTEGER NOT NULL DEFAULT 0, `codec` TEXT NOT NULL DEFAULT '', `artist` TEXT NOT NULL DEFAULT '', `album` TEXT NOT NULL DEFAULT '', `track` INTEGER NOT NULL DEFAULT 0);",
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_song_versions_song_id_number ON song_versions (song_id, number);",
+			"INSERT INTO `song_versions` (song_id, number, created, filename, size, duration, bitrate, sample_rate, channels, codec, artist, album, track) SELECT id, 1, created, filename, size, duration, bitrate, sample_rate, channels, codec, artist, album, track FROM songs;",
+			"ALTER TABLE `songs` ADD COLUMN `current_version` INTEGER NOT NULL DEFAULT 0;",
+			"UPDATE `songs` SET `current_version` = (SELECT id FROM song_versions WHERE song_versions.song_id = songs.id AND song_versions.number = 1);",
+			// whether listeners may play and download versions other than the current one
+			"ALTER TABLE `songs` ADD COLUMN `versions_public` INTEGER NOT NULL DEFAULT 0;",
+		},
+	},
+	{
+		Version:     12,
+		Description: "Add license to songs",
+		Statements: []string{
+			// songs uploaded so far were released under CC BY-NC-SA
+			"ALTER TABLE `songs` ADD COLUMN `license` TEXT NOT NULL DEFAULT 'cc-by-nc-sa';",
+		},
+	},
+	{
+		Version:     13,
+		Description: "Add visibility, release time and share token to songs",
+		Statements: []string{
+			"ALTER TABLE `songs` ADD COLUMN `visibility` TEXT NOT NULL DEFAULT 'public';",
+			// 0 releases a song as soon as it is uploaded
+			"ALTER TABLE `songs` ADD COLUMN `release_at` INTEGER NOT NULL DEFAULT 0;",
+			"ALTER TABLE `songs` ADD COLUMN `share_token` TEXT NOT NULL DEFAULT '';",
+			"UPDATE `songs` SET `share_token` = lower(hex(randomblob(16)));",
+			"CREATE INDEX IF NOT EXISTS `songs_listed` ON `songs` (`visibility`, `release_at`);",
+		},
+	},
+	{
+		Version:     14,
+		Description: "Add full-text search over songs and artists",
+		Statements: []string{
+			// rowid is the song's id; tags holds the artist and album embedded in the file
+			"CREATE VIRTUAL TABLE IF NOT EXISTS `song_search` USING fts5(title, description, tags, artist, tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3');",
+			"INSERT INTO `song_search` (rowid, title, description, tags, artist) SELECT songs.id, songs.title, songs.description, trim(songs.artist || ' ' || songs.album), users.username FROM songs INNER JOIN users ON users.id = songs.user_id;",
+			"CREATE TRIGGER IF NOT EXISTS `songs_search_insert` AFTER INSERT ON `songs` BEGIN " +
+				"INSERT INTO song_search (rowid, title, description, tags, artist) VALUES (new.id, new.title, new.description, trim(new.artist || ' ' || new.album), (SELECT username FROM users WHERE id = new.user_id)); " +
+				"END;",
+			"CREATE TRIGGER IF NOT EXISTS `songs_search_update` AFTER UPDATE OF title, description, artist, album ON `songs` BEGIN " +
+				"UPDATE song_search SET title = new.title, description = new.description, tags = trim(new.artist || ' ' || new.album) WHERE rowid = new.id; " +
+				"END;",
+			"CREATE TRIGGER IF NOT EXISTS `songs_search_delete` AFTER DELETE ON `songs` BEGIN " +
+				"DELETE FROM song_search WHERE rowid = old.id; " +
+				"END;",
+			// rowid is the user's id
+			"CREATE VIRTUAL TABLE IF NOT EXISTS `user_search` USING fts5(username, tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3');",
+			"INSERT INTO `user_search` (rowid, username) SELECT id, username FROM users;",
+			"CREATE TRIGGER IF NOT EXISTS `users_search_insert` AFTER INSERT ON `users` BEGIN " +
+				"INSERT INTO user_search (rowid, username) VALUES (new.id, new.username); " +
+				"END;",
+			"CREATE TRIGGER IF NOT EXISTS `users_search_update` AFTER UPDATE OF username ON `users` BEGIN " +
+				"UPDATE user_search SET username = new.username WHERE rowid = new.id; " +
+				"UPDATE song_search SET artist = new.username WHERE rowid IN (SELECT id FROM songs WHERE user_id = new.id); " +
+				"END;",
+			"CREATE TRIGGER IF NOT EXISTS `users_search_delete` AFTER DELETE ON `users` BEGIN " +
+				"DELETE FROM user_search WHERE rowid = old.id; " +
+				"END;",
+		},
+	},
+	{
+		Version:     15,
+		Description: "Add follows between users",
+		Statements: []string{
+			"CREATE TABLE IF NOT EXISTS `follows` (`follower_id` INTEGER NOT NULL, `following_id` INTEGER NOT NULL, `created` INTEGER NOT NULL, PRIMARY KEY (`follower_id`, `following_id`));",
+			"CREATE INDEX IF NOT EXISTS `follows_following` ON `follows` (`following_id`);",
+		},
+	},
+	{
+		Version:     16,
+		Description: "Add playlists",
+		Statements: []string{
+			"CREATE TABLE IF NOT EXISTS `playlists` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `user_id` INTEGER NOT NULL, `public_id` TEXT NOT NULL, `title` TEXT NOT NULL, `description` TEXT NOT NULL DEFAULT '', `visibility` TEXT NOT NULL DEFAULT 'public', `share_token` TEXT NOT NULL, `created` INTEGER NOT NULL, `updated` INTEGER NOT NULL);",
+			"CREATE UNIQUE INDEX IF NOT EXISTS `playlists_public_id` ON `playlists` (`public_id`);",
+			"CREATE INDEX IF NOT EXISTS `playlists_user` ON `playlists` (`user_id`);",
+			// position orders the songs in a playlist, counting up from 1
+			"CREATE TABLE IF NOT EXISTS `playlist_songs` (`playlist_id` INTEGER NOT NULL, `song_id` INTEGER NOT NULL, `position` INTEGER NOT NULL, `added_by` INTEGER NOT NULL, `created` INTEGER NOT NULL, PRIMARY KEY (`playlist_id`, `song_id`));",
+			"CREATE INDEX IF NOT EXISTS `playlist_songs_song` ON `playlist_songs` (`song_id`);",
+			"CREATE TABLE IF NOT EXISTS `playlist_collaborators` (`playlist_id` INTEGER NOT NULL, `user_id` INTEGER NOT NULL, `created` INTEGER NOT NULL, PRIMARY KEY (`playlist_id`, `user_id`));",
+			"CREATE INDEX IF NOT EXISTS `playlist_collaborators_user` ON `playlist_collaborators` (`user_id`);",
+		},
+	},
+	{
+		Version:     17,
+		Description: "Add albums",
+		Statements: []string{
+			// release_date is midnight UTC of the day the album came out, cover is its object key in the owner's bucket
+			"CREATE TABLE IF NOT EXISTS `albums` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `user_id` INTEGER NOT NULL, `public_id` TEXT NOT NULL, `title` TEXT NOT NULL, `description` TEXT NOT NULL DEFAULT '', `release_date` INTEGER NOT NULL DEFAULT 0, `cover` TEXT NOT NULL DEFAULT '', `cover_size` INTEGER NOT NULL DEFAULT 0, `created` INTEGER NOT NULL, `updated` INTEGER NOT NULL);",
+			"CREATE UNIQUE INDEX IF NOT EXISTS `albums_public_id` ON `albums` (`public_id`);",
+			"CREATE INDEX IF NOT EXISTS `albums_user` ON `albums` (`user_id`);",
+			// A song is a track of at most one album, position counts up from 1
+			"CREATE TABLE IF NOT EXISTS `album_tracks` (`song_id` INTEGER PRIMARY KEY, `album_id` INTEGER NOT NULL, `position` INTEGER NOT NULL);",
+			"CREATE INDEX IF NOT EXISTS `album_tracks_album` ON `album_tracks` (`album_id`, `position`);",
+		},
+	},
+	{
+		Version:     18,
+		Description: "Add images for avatars and covers",
+		Statements: []string{
+			// original is the object key of the uploaded file, its thumbnails are stored next to it. size counts both.
+			"CREATE TABLE IF NOT EXISTS `images` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `user_id` INTEGER NOT NULL, `public_id` TEXT NOT NULL, `original` TEXT NOT NULL, `size` INTEGER NOT NULL DEFAULT 0, `created` INTEGER NOT NULL);",
+			"CREATE UNIQUE INDEX IF NOT EXISTS `images_public_id` ON `images` (`public_id`);",
+			"CREATE INDEX IF NOT EXISTS `images_user` ON `images` (`user_id`);",
+			"ALTER TABLE `users` ADD COLUMN `avatar_id` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `songs` ADD COLUMN `cover_id` TEXT NOT NULL DEFAULT '';",
+			"ALTER TABLE `albums` ADD COLUMN `cover_id` TEXT NOT NULL DEFAULT '';",
+			// Existing album covers become images whose thumbnails are made when first requested
+			"INSERT INTO `images` (user_id, public_id, original, size, created) SELECT user_id, lower(hex(randomblob(8))), cover, cover_size, updated FROM albums WHERE cover != '';",
+			"UPDATE `albums` SET cover_id = (SELECT public_id FROM images WHERE images.user_id = albums.user_id AND images.original = albums.cover) WHERE cover != '';",
+			"ALTER TABLE `albums` DROP COLUMN `cover`;",
+			"ALTER TABLE `albums` DROP COLUMN `cover_size`;",
+		},
+	},
+	{
+		Version:     19,
+		Description: "Remove likes left behind by deleted songs",
+		Statements: []string{
+			"DELETE FROM `likes` WHERE type = 1 AND ref_id NOT IN (SELECT id FROM songs);",
+			"DELETE FROM `likes` WHERE type = 2 AND ref_id NOT IN (SELECT id FROM comments);",
+		},
+	},
+}
+
+// LatestVersion is the schema version this binary migrates databases to
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// PendingMigrations opens the database at DBPath without changing it and lists migrations not yet applied
+func PendingMigrations(ctx context.Context, DBPath string) ([]Migration, error) {
+	// Nothing has been applied to a database which was never created
+	if _, err := os.Stat(DBPath); os.IsNotExist(err) {
+		return pendingAfter(0)
+	}
+
+	sqlite, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?cache=shared&mode=ro", DBPath))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sqlite.Close() }()
+
+	var exists int
+	err = sqlite.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type='table' AND name='schema_version';").Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	current := 0
+	if exists > 0 {
+		err = sqlite.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version;").Scan(&current)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pendingAfter(current)
+}
+
+// migrate brings the schema up to LatestVersion inside tx
+func migrate(tx *sql.Tx) error {
+	_, err := tx.Exec("CREATE TABLE IF NOT EXISTS `schema_version` (`version` INTEGER PRIMARY KEY, `description` TEXT, `applied` INTEGER);")
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version;").Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	pending, err := pendingAfter(current)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		for _, statement := range m.Statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+			}
+		}
+
+		_, err = tx.Exec("INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?);", m.Version, m.Description, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pendingAfter returns the migrations newer than version
+func pendingAfter(version int) ([]Migration, error) {
+	if version > LatestVersion() {
+		return nil, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, version, LatestVersion())
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,351 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrNotInPlaylist is returned when reordering a playlist with a song it does not contain
+var ErrNotInPlaylist = errors.New("the song is not in the playlist")
+
+// Playlist struct matches row on `playlists` table
+type Playlist struct {
+	ID          int
+	UserID      int
+	PublicID    string
+	Title       string
+	Description string
+	Visibility  string
+	// ShareToken is the key in the secret link to an unlisted playlist
+	ShareToken string
+	Created    int
+	Updated    int
+}
+
+// PlaylistEntry is a song in a playlist
+type PlaylistEntry struct {
+	Song
+	Artist   string
+	Position int
+	AddedBy  int
+}
+
+// playlistColumns are selected by every query which scans a Playlist
+const playlistColumns = "playlists.id, playlists.user_id, playlists.public_id, playlists.title, playlists.description, playlists.visibility, playlists.share_token, playlists.created, playlists.updated"
+
+// scanPlaylist reads a row selected with playlistColumns
+func scanPlaylist(row scanner) (playlist Playlist, err error) {
+	err = row.Scan(&playlist.ID, &playlist.UserID, &playlist.PublicID, &playlist.Title, &playlist.Description, &playlist.Visibility, &playlist.ShareToken,
+		&playlist.Created, &playlist.Updated)
+	return playlist, err
+}
+
+// queryPlaylists runs a query selecting playlistColumns
+func (db *DB) queryPlaylists(query string, args ...interface{}) (playlists []Playlist, err error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		playlists = append(playlists, playlist)
+	}
+
+	return playlists, rows.Err()
+}
+
+// AddPlaylist creates an empty playlist owned by a user
+func (db *DB) AddPlaylist(userID int, title, description, visibility string) (int64, error) {
+	defer db.locked()()
+
+	publicID, err := newPublicID()
+	if err != nil {
+		return 0, err
+	}
+
+	shareToken, err := newShareToken()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	res, err := db.DB.Exec("INSERT INTO playlists (user_id, public_id, title, description, visibility, share_token, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?);",
+		userID, publicID, title, description, visibility, shareToken, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// GetPlaylist returns a playlist by id
+func (db *DB) GetPlaylist(id int) (Playlist, error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+playlistColumns+" FROM playlists WHERE id=?;", id)
+	return scanPlaylist(row)
+}
+
+// GetPlaylistByPublicID returns a playlist by the ID used in its links
+func (db *DB) GetPlaylistByPublicID(publicID string) (Playlist, error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+playlistColumns+" FROM playlists WHERE public_id=?;", publicID)
+	return scanPlaylist(row)
+}
+
+// GetPlaylistsForUser returns the public playlists a user owns, or all of them if hidden is set, most recently changed first
+func (db *DB) GetPlaylistsForUser(userID int, hidden bool) ([]Playlist, error) {
+	defer db.locked()()
+
+	if hidden {
+		return db.queryPlaylists("SELECT "+playlistColumns+" FROM playlists WHERE user_id=? ORDER BY updated DESC;", userID)
+	}
+
+	return db.queryPlaylists("SELECT "+playlistColumns+" FROM playlists WHERE user_id=? AND visibility=? ORDER BY updated DESC;", userID, VisibilityPublic)
+}
+
+// GetEditablePlaylists returns the playlists a user owns or collaborates on, most recently changed first
+func (db *DB) GetEditablePlaylists(userID int) ([]Playlist, error) {
+	defer db.locked()()
+
+	return db.queryPlaylists("SELECT "+playlistColumns+" FROM playlists WHERE user_id=? "+
+		"OR id IN (SELECT playlist_id FROM playlist_collaborators WHERE user_id=?) ORDER BY updated DESC;", userID, userID)
+}
+
+// UpdatePlaylist changes a playlist's title, description and who can find it
+func (db *DB) UpdatePlaylist(playlistID int, title, description, visibility string) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE playlists SET title=?, description=?, visibility=?, updated=? WHERE id=?;",
+		title, description, visibility, time.Now().Unix(), playlistID)
+	return err
+}
+
+// DeletePlaylist deletes a playlist along with its songs and collaborators.
+// The songs themselves are kept.
+func (db *DB) DeletePlaylist(playlistID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, statement := range []string{
+		"DELETE FROM playlist_songs WHERE playlist_id=?;",
+		"DELETE FROM playlist_collaborators WHERE playlist_id=?;",
+		"DELETE FROM playlists WHERE id=?;",
+	} {
+		if _, err := tx.Exec(statement, playlistID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPlaylistSongs returns the songs in a playlist in order, with their artists
+func (db *DB) GetPlaylistSongs(playlistID int) (entries []PlaylistEntry, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT "+songColumns+", users.username, playlist_songs.position, playlist_songs.added_by FROM playlist_songs "+
+		"INNER JOIN songs ON songs.id = playlist_songs.song_id INNER JOIN users ON users.id = songs.user_id "+
+		"WHERE playlist_songs.playlist_id=? ORDER BY playlist_songs.position;", playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry PlaylistEntry
+
+		entry.Song, err = scanSong(rows, &entry.Artist, &entry.Position, &entry.AddedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// AddPlaylistSong adds a song to the end of a playlist. Songs already in the playlist stay where they are.
+func (db *DB) AddPlaylistSong(playlistID, songID, addedBy int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().Unix()
+	_, err = tx.Exec("INSERT OR IGNORE INTO playlist_songs (playlist_id, song_id, position, added_by, created) "+
+		"SELECT ?, ?, COALESCE(MAX(position), 0) + 1, ?, ? FROM playlist_songs WHERE playlist_id=?;",
+		playlistID, songID, addedBy, now, playlistID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE playlists SET updated=? WHERE id=?;", now, playlistID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemovePlaylistSong takes a song out of a playlist
+func (db *DB) RemovePlaylistSong(playlistID, songID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM playlist_songs WHERE playlist_id=? AND song_id=?;", playlistID, songID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE playlists SET updated=? WHERE id=?;", time.Now().Unix(), playlistID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReorderPlaylist puts the songs of a playlist in the order of songIDs.
+// Songs left out of songIDs keep their order after the ones given.
+func (db *DB) ReorderPlaylist(playlistID int, songIDs []int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := reorderSongs(tx, "playlist_songs", "playlist_id", playlistID, songIDs, ErrNotInPlaylist); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE playlists SET updated=? WHERE id=?;", time.Now().Unix(), playlistID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// reorderSongs renumbers the positions of the songs in table belonging to id in column to follow songIDs.
+// Songs left out of songIDs keep their order after the ones given. missing is returned for a song which is not there.
+func reorderSongs(tx *sql.Tx, table, column string, id int, songIDs []int, missing error) error {
+	rows, err := tx.Query("SELECT song_id FROM "+table+" WHERE "+column+"=? ORDER BY position;", id)
+	if err != nil {
+		return err
+	}
+
+	var current []int
+	for rows.Next() {
+		var songID int
+		if err := rows.Scan(&songID); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, songID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	remaining := make(map[int]bool, len(current))
+	for _, songID := range current {
+		remaining[songID] = true
+	}
+
+	order := make([]int, 0, len(current))
+	for _, songID := range songIDs {
+		if !remaining[songID] {
+			return missing
+		}
+		delete(remaining, songID)
+		order = append(order, songID)
+	}
+
+	for _, songID := range current {
+		if remaining[songID] {
+			order = append(order, songID)
+		}
+	}
+
+	for i, songID := range order {
+		if _, err := tx.Exec("UPDATE "+table+" SET position=? WHERE "+column+"=? AND song_id=?;", i+1, id, songID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddPlaylistCollaborator lets a user add, remove and reorder the songs in a playlist
+func (db *DB) AddPlaylistCollaborator(playlistID, userID int) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("INSERT OR IGNORE INTO playlist_collaborators (playlist_id, user_id, created) VALUES (?, ?, ?);", playlistID, userID, time.Now().Unix())
+	return err
+}
+
+// RemovePlaylistCollaborator stops a user changing the songs in a playlist
+func (db *DB) RemovePlaylistCollaborator(playlistID, userID int) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("DELETE FROM playlist_collaborators WHERE playlist_id=? AND user_id=?;", playlistID, userID)
+	return err
+}
+
+// IsPlaylistCollaborator reports whether a user may change the songs in a playlist they do not own
+func (db *DB) IsPlaylistCollaborator(playlistID, userID int) bool {
+	defer db.locked()()
+
+	var count int
+	row := db.DB.QueryRow("SELECT COUNT(*) FROM playlist_collaborators WHERE playlist_id=? AND user_id=?;", playlistID, userID)
+	if err := row.Scan(&count); err != nil {
+		return false
+	}
+
+	return count > 0
+}
+
+// GetPlaylistCollaborators returns the users who may change the songs in a playlist, by name
+func (db *DB) GetPlaylistCollaborators(playlistID int) (users []User, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT users.id, users.created, users.username FROM playlist_collaborators INNER JOIN users ON users.id = playlist_collaborators.user_id "+
+		"WHERE playlist_collaborators.playlist_id=? ORDER BY users.username;", playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Created, &user.Username); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
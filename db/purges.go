@@ -0,0 +1,53 @@
+package db
+
+import (
+	"time"
+)
+
+// PendingPurge is a bucket of a deleted account which still has to be emptied and removed
+type PendingPurge struct {
+	Bucket      string
+	Created     int
+	Attempts    int
+	NextAttempt int
+	LastError   string
+}
+
+// GetDuePurges returns pending purges which are ready to be attempted again
+func (db *DB) GetDuePurges() (purges []PendingPurge, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT bucket, created, attempts, next_attempt, last_error FROM pending_purges WHERE next_attempt<=? ORDER BY next_attempt;", time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var purge PendingPurge
+		err = rows.Scan(&purge.Bucket, &purge.Created, &purge.Attempts, &purge.NextAttempt, &purge.LastError)
+		if err != nil {
+			return nil, err
+		}
+
+		purges = append(purges, purge)
+	}
+
+	return purges, rows.Err()
+}
+
+// DeferPurge records a failed purge and when to try it again
+func (db *DB) DeferPurge(bucket string, next time.Time, lastError string) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE pending_purges SET attempts=attempts+1, next_attempt=?, last_error=? WHERE bucket=?", next.Unix(), lastError, bucket)
+	return err
+}
+
+// FinishPurge removes a purge once the bucket has been deleted
+func (db *DB) FinishPurge(bucket string) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("DELETE FROM pending_purges WHERE bucket=?", bucket)
+	return err
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Matched text in search results is wrapped in these markers so callers can escape the rest before highlighting it
+const (
+	SearchMarkStart = "\x02"
+	SearchMarkEnd   = "\x03"
+)
+
+// maxSearchTerms keeps very long queries from building huge match expressions
+const maxSearchTerms = 8
+
+// SongSearchResult is a song matching a search
+type SongSearchResult struct {
+	Song
+	Artist string
+
+	// TitleHighlight and Snippet have the matched words wrapped in SearchMarkStart and SearchMarkEnd
+	TitleHighlight string
+	Snippet        string
+}
+
+// searchMatch turns what someone typed into an FTS5 match expression.
+// Every word must match, as a whole word or as the start of one.
+// It returns "" if there is nothing to search for.
+func searchMatch(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+
+	// Quoting each word keeps FTS5 operators such as AND, NOT and column filters out of the expression
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// SearchSongs returns the songs listed for everyone which match query, best matches first,
+// and how many match in total.
+// Titles count the most, then tags, the artist's name and the description.
+func (db *DB) SearchSongs(query string, limit, offset int) (results []SongSearchResult, total int, err error) {
+	match := searchMatch(query)
+	if match == "" {
+		return nil, 0, nil
+	}
+
+	defer db.locked()()
+
+	now := time.Now().Unix()
+
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM song_search INNER JOIN songs ON songs.id = song_search.rowid WHERE song_search MATCH ? AND "+listedSongs+";",
+		match, now).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.DB.Query("SELECT "+songColumns+", song_search.artist, "+
+		"highlight(song_search, 0, '"+SearchMarkStart+"', '"+SearchMarkEnd+"'), "+
+		"snippet(song_search, 1, '"+SearchMarkStart+"', '"+SearchMarkEnd+"', '…', 16) "+
+		"FROM song_search INNER JOIN songs ON songs.id = song_search.rowid "+
+		"WHERE song_search MATCH ? AND "+listedSongs+" "+
+		"ORDER BY bm25(song_search, 10.0, 1.0, 4.0, 3.0), songs.id DESC LIMIT ? OFFSET ?;",
+		match, now, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result SongSearchResult
+
+		result.Song, err = scanSong(rows, &result.Artist, &result.TitleHighlight, &result.Snippet)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, total, rows.Err()
+}
+
+// SearchUsers returns up to limit users whose name matches query, best matches first
+func (db *DB) SearchUsers(query string, limit int) (users []User, err error) {
+	match := searchMatch(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT users.id, users.created, users.username, users.email, users.avatar_id FROM user_search INNER JOIN users ON users.id = user_search.rowid "+
+		"WHERE user_search MATCH ? ORDER BY rank, users.username LIMIT ?;", match, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Created, &user.Username, &user.Email, &user.AvatarID); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
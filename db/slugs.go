@@ -0,0 +1,214 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxSlugLength keeps song URLs readable for very long titles
+const maxSlugLength = 60
+
+// Slugify turns a title into lowercase ASCII words joined by dashes
+func Slugify(title string) string {
+	var slug strings.Builder
+	dash := false
+
+	// Decompose accented letters so their base letter is kept
+	for _, r := range norm.NFKD.String(title) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			dash = false
+			slug.WriteRune(unicode.ToLower(r))
+		case unicode.Is(unicode.Mn, r):
+			// combining marks left over from decomposition
+		default:
+			dash = true
+		}
+
+		if slug.Len() >= maxSlugLength {
+			break
+		}
+	}
+
+	result := strings.Trim(slug.String(), "-")
+	if result == "" {
+		return "song"
+	}
+
+	return result
+}
+
+// newPublicID generates the random ID a song keeps for its whole life
+func newPublicID() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(random), nil
+}
+
+// newShareToken generates the key in the secret link to an unlisted song
+func newShareToken() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(random), nil
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// slugFor reports whether slug is base or base with a number added to make it unique
+func slugFor(slug, base string) bool {
+	if slug == base {
+		return true
+	}
+
+	suffix := strings.TrimPrefix(slug, base+"-")
+	if suffix == slug || suffix == "" {
+		return false
+	}
+
+	_, err := strconv.Atoi(suffix)
+	return err == nil
+}
+
+// uniqueSlug returns a slug for title which no other song of the user has or used to have.
+// Slugs which redirect to songID itself may be reused.
+func uniqueSlug(q queryer, userID, songID int, title string) (string, error) {
+	base := Slugify(title)
+
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			slug = base + "-" + strconv.Itoa(n)
+		}
+
+		var taken int
+		err := q.QueryRow("SELECT (SELECT COUNT(*) FROM songs WHERE user_id=? AND slug=?) + (SELECT COUNT(*) FROM song_redirects WHERE user_id=? AND slug=? AND song_id!=?);", userID, slug, userID, slug, songID).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+
+		if taken == 0 {
+			return slug, nil
+		}
+	}
+}
+
+// backfillSongSlugs gives songs uploaded before slugs existed one based on their title
+func backfillSongSlugs(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, user_id, title FROM songs WHERE slug='' ORDER BY id;")
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		id, userID int
+		title      string
+	}
+
+	var songs []pending
+	for rows.Next() {
+		var song pending
+		if err := rows.Scan(&song.id, &song.userID, &song.title); err != nil {
+			rows.Close()
+			return err
+		}
+		songs = append(songs, song)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, song := range songs {
+		slug, err := uniqueSlug(tx, song.userID, song.id, song.title)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE songs SET slug=? WHERE id=?;", slug, song.id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetSongBySlug returns a user's song by its current slug.
+// If the slug belonged to the song before its title changed, moved is true.
+func (db *DB) GetSongBySlug(userID int, slug string) (song Song, moved bool, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+songColumns+" FROM songs WHERE user_id=? AND slug=?;", userID, slug)
+	song, err = scanSong(row)
+	if err != sql.ErrNoRows {
+		return song, false, err
+	}
+
+	row = db.DB.QueryRow("SELECT "+songColumns+" FROM songs INNER JOIN song_redirects ON song_redirects.song_id = songs.id WHERE song_redirects.user_id=? AND song_redirects.slug=?;", userID, slug)
+	song, err = scanSong(row)
+	return song, err == nil, err
+}
+
+// GetSongByPublicID returns a song by the ID used in its permanent link
+func (db *DB) GetSongByPublicID(publicID string) (Song, error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+songColumns+" FROM songs WHERE public_id=?;", publicID)
+	return scanSong(row)
+}
+
+// retitleSong changes a song's title and slug, keeping the old slug as a redirect
+func retitleSong(tx *sql.Tx, songID int, title string) error {
+	var userID int
+	var oldSlug string
+	err := tx.QueryRow("SELECT user_id, slug FROM songs WHERE id=?;", songID).Scan(&userID, &oldSlug)
+	if err != nil {
+		return err
+	}
+
+	// Keep the slug if the new title still maps to it
+	slug := oldSlug
+	if !slugFor(oldSlug, Slugify(title)) {
+		slug, err = uniqueSlug(tx, userID, songID, title)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE songs SET title=?, slug=? WHERE id=?;", title, slug, songID); err != nil {
+		return err
+	}
+
+	if slug != oldSlug {
+		// The new slug may have been one of this song's old ones
+		if _, err := tx.Exec("DELETE FROM song_redirects WHERE user_id=? AND slug=?;", userID, slug); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO song_redirects (user_id, slug, song_id, created) VALUES (?, ?, ?, ?);", userID, oldSlug, songID, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package db
+
+// StorageUsage returns how many bytes of songs, counting every version, and images a user has uploaded and how many they may upload.
+// Users without their own quota get defaultQuota.
+func (db *DB) StorageUsage(userID int, defaultQuota int64) (used, quota int64, err error) {
+	defer db.locked()()
+
+	err = db.DB.QueryRow("SELECT (SELECT COALESCE(SUM(song_versions.size), 0) FROM song_versions INNER JOIN songs ON songs.id = song_versions.song_id WHERE songs.user_id=?) + "+
+		"(SELECT COALESCE(SUM(size), 0) FROM images WHERE user_id=?);", userID, userID).Scan(&used)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = db.DB.QueryRow("SELECT storage_quota FROM users WHERE id=?;", userID).Scan(&quota)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if quota == 0 {
+		quota = defaultQuota
+	}
+
+	return used, quota, nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// APIToken struct matches row on `api_tokens` table
+type APIToken struct {
+	ID       int
+	Created  int
+	UserID   int
+	Name     string
+	Hash     []byte
+	Scopes   string
+	LastUsed int
+}
+
+// HasScope checks if the token was granted scope
+func (t APIToken) HasScope(scope string) bool {
+	for _, granted := range strings.Split(t.Scopes, ",") {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddAPIToken to the database
+// Only the hash of the token is stored
+func (db *DB) AddAPIToken(userID int, name string, hash []byte, scopes []string) (int64, error) {
+	defer db.locked()()
+
+	created := time.Now().Unix()
+	res, err := db.DB.Exec("INSERT INTO api_tokens (created, user_id, name, hash, scopes, last_used) VALUES (?, ?, ?, ?, ?, 0);", created, userID, name, hash, strings.Join(scopes, ","))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// GetAPITokenByHash returns the token matching a hash
+func (db *DB) GetAPITokenByHash(hash []byte) (result APIToken, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT id, created, user_id, name, hash, scopes, last_used FROM api_tokens WHERE hash=? LIMIT 1;", hash)
+	err = row.Scan(&result.ID, &result.Created, &result.UserID, &result.Name, &result.Hash, &result.Scopes, &result.LastUsed)
+	return result, err
+}
+
+// GetAPITokensForUser returns all tokens a user has created
+func (db *DB) GetAPITokensForUser(userID int) (tokens []APIToken, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT id, created, user_id, name, hash, scopes, last_used FROM api_tokens WHERE user_id=? ORDER BY created DESC;", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token APIToken
+
+		if err := rows.Scan(&token.ID, &token.Created, &token.UserID, &token.Name, &token.Hash, &token.Scopes, &token.LastUsed); err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken records that a token was just used
+func (db *DB) TouchAPIToken(tokenID int) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("UPDATE api_tokens SET last_used=? WHERE id=?", time.Now().Unix(), tokenID)
+	return err
+}
+
+// DeleteAPIToken revokes one of a user's tokens
+func (db *DB) DeleteAPIToken(userID, tokenID int) error {
+	defer db.locked()()
+
+	_, err := db.DB.Exec("DELETE FROM api_tokens WHERE id=? AND user_id=?", tokenID, userID)
+	return err
+}
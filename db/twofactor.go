@@ -0,0 +1,101 @@
+package db
+
+// GetTOTPSecret returns the user's TOTP secret, or "" if two-factor authentication is disabled
+func (db *DB) GetTOTPSecret(userID int) (secret string, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT totp_secret FROM users WHERE id=? LIMIT 1;", userID)
+	err = row.Scan(&secret)
+	return secret, err
+}
+
+// EnableTOTP stores a TOTP secret for the user and replaces their recovery codes
+// Only the hashes of the recovery codes are stored
+func (db *DB) EnableTOTP(userID int, secret string, codeHashes [][]byte) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret=?, totp_last_step=0 WHERE id=?", secret, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id=?", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?);", userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes the user's TOTP secret and recovery codes
+func (db *DB) DisableTOTP(userID int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret='', totp_last_step=0 WHERE id=?", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id=?", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code.
+// It returns false if a code from this step or a later one was already used.
+func (db *DB) UseTOTPStep(userID int, step int64) (bool, error) {
+	defer db.locked()()
+
+	res, err := db.DB.Exec("UPDATE users SET totp_last_step=? WHERE id=? AND totp_last_step<?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// UseRecoveryCode deletes a recovery code so it cannot be used again.
+// It returns false if the user has no recovery code matching hash.
+func (db *DB) UseRecoveryCode(userID int, hash []byte) (bool, error) {
+	defer db.locked()()
+
+	res, err := db.DB.Exec("DELETE FROM recovery_codes WHERE id=(SELECT id FROM recovery_codes WHERE user_id=? AND hash=? LIMIT 1)", userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// RecoveryCodeCount returns how many unused recovery codes the user has left
+func (db *DB) RecoveryCodeCount(userID int) (count int, err error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id=?", userID)
+	err = row.Scan(&count)
+	return count, err
+}
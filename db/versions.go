@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrCurrentVersion is returned when deleting the version of a song which is being played
+var ErrCurrentVersion = errors.New("the current version of a song cannot be deleted")
+
+// SongFile is an audio object in a user's bucket and what was read from it
+type SongFile struct {
+	Filename string
+	Size     int64 // bytes
+	SongMeta
+}
+
+// SongVersion is one of the audio files uploaded for a song
+type SongVersion struct {
+	ID      int
+	SongID  int
+	Number  int // counts up from 1 for each song
+	Created int
+	Notes   string
+	SongFile
+}
+
+// songVersionColumns are selected by every query which scans a SongVersion
+const songVersionColumns = "id, song_id, number, created, notes, filename, size, duration, bitrate, sample_rate, channels, codec, artist, album, track"
+
+// scanSongVersion reads a row selected with songVersionColumns
+func scanSongVersion(row scanner) (version SongVersion, err error) {
+	err = row.Scan(&version.ID, &version.SongID, &version.Number, &version.Created, &version.Notes, &version.Filename, &version.Size,
+		&version.Duration, &version.Bitrate, &version.SampleRate, &version.Channels, &version.Codec, &version.Artist, &version.Album, &version.Track)
+	return version, err
+}
+
+// addSongVersion records file as the newest version of a song and makes it current
+func addSongVersion(tx *sql.Tx, songID int, file SongFile, notes string) (int64, error) {
+	var number int
+	err := tx.QueryRow("SELECT COALESCE(MAX(number), 0) + 1 FROM song_versions WHERE song_id=?;", songID).Scan(&number)
+	if err != nil {
+		return 0, err
+	}
+
+	meta := file.SongMeta
+	res, err := tx.Exec("INSERT INTO song_versions (song_id, number, created, notes, filename, size, duration, bitrate, sample_rate, channels, codec, artist, album, track) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		songID, number, time.Now().Unix(), notes, file.Filename, file.Size, meta.Duration, meta.Bitrate, meta.SampleRate, meta.Channels, meta.Codec, meta.Artist, meta.Album, meta.Track)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, setCurrentVersion(tx, songID, int(id))
+}
+
+// setCurrentVersion copies a version's file into the songs row so it is the one played
+func setCurrentVersion(tx *sql.Tx, songID, versionID int) error {
+	row := tx.QueryRow("SELECT "+songVersionColumns+" FROM song_versions WHERE id=? AND song_id=?;", versionID, songID)
+	version, err := scanSongVersion(row)
+	if err != nil {
+		return err
+	}
+
+	meta := version.SongMeta
+	_, err = tx.Exec("UPDATE songs SET current_version=?, filename=?, size=?, duration=?, bitrate=?, sample_rate=?, channels=?, codec=?, artist=?, album=?, track=? WHERE id=?;",
+		version.ID, version.Filename, version.Size, meta.Duration, meta.Bitrate, meta.SampleRate, meta.Channels, meta.Codec, meta.Artist, meta.Album, meta.Track, songID)
+	return err
+}
+
+// GetSongVersions returns every version of a song, newest first
+func (db *DB) GetSongVersions(songID int) (versions []SongVersion, err error) {
+	defer db.locked()()
+
+	rows, err := db.DB.Query("SELECT "+songVersionColumns+" FROM song_versions WHERE song_id=? ORDER BY number DESC;", songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		version, err := scanSongVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// GetSongVersion returns a version of a song by its number
+func (db *DB) GetSongVersion(songID, number int) (SongVersion, error) {
+	defer db.locked()()
+
+	row := db.DB.QueryRow("SELECT "+songVersionColumns+" FROM song_versions WHERE song_id=? AND number=?;", songID, number)
+	return scanSongVersion(row)
+}
+
+// SetCurrentSongVersion makes a version of a song the one which is played
+func (db *DB) SetCurrentSongVersion(songID, number int) error {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var versionID int
+	err = tx.QueryRow("SELECT id FROM song_versions WHERE song_id=? AND number=?;", songID, number).Scan(&versionID)
+	if err != nil {
+		return err
+	}
+
+	if err := setCurrentVersion(tx, songID, versionID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSongVersion removes a version of a song other than the current one
+// and returns its filename so the object can be deleted
+func (db *DB) DeleteSongVersion(songID, number int) (string, error) {
+	defer db.locked()()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var versionID, current int
+	var filename string
+	err = tx.QueryRow("SELECT song_versions.id, song_versions.filename, songs.current_version FROM song_versions INNER JOIN songs ON songs.id = song_versions.song_id WHERE song_versions.song_id=? AND song_versions.number=?;",
+		songID, number).Scan(&versionID, &filename, &current)
+	if err != nil {
+		return "", err
+	}
+
+	if versionID == current {
+		return "", ErrCurrentVersion
+	}
+
+	if _, err := tx.Exec("DELETE FROM song_versions WHERE id=?;", versionID); err != nil {
+		return "", err
+	}
+
+	return filename, tx.Commit()
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/tardigradio/website/db"
+)
+
+const (
+	// defaultSessionTTL is how long sessions without a MaxAge are kept in redis
+	defaultSessionTTL = 30 * 24 * time.Hour
+	// sessionSeenInterval is how often the device a session was last used from is recorded
+	sessionSeenInterval = time.Minute
+	// sessionTouchedKey marks a request whose session has already been recorded as seen
+	sessionTouchedKey = "sessionTouched"
+)
+
+var errUnknownSession = errors.New("Unknown session")
+
+// Device describes a signed in session for the settings page
+type Device struct {
+	ID        string
+	IP        string
+	UserAgent string
+	Created   string
+	LastSeen  string
+	Current   bool
+
+	seen int64
+}
+
+// sessionTTL returns how long a session should be kept in redis
+func sessionTTL(options *gsessions.Options) time.Duration {
+	if options.MaxAge > 0 {
+		return time.Duration(options.MaxAge) * time.Second
+	}
+
+	return defaultSessionTTL
+}
+
+// sessionMetaKey is the redis hash describing the device a session belongs to
+func sessionMetaKey(id string) string {
+	return "session:meta:" + id
+}
+
+// sessionSeenKey exists while the last seen time of a session is recent enough not to be updated
+func sessionSeenKey(id string) string {
+	return "session:seen:" + id
+}
+
+// userSessionsKey is the redis set of a user's session IDs
+func userSessionsKey(userID int) string {
+	return "session:user:" + strconv.Itoa(userID)
+}
+
+// startSession logs a user in on the current session, giving it a new ID
+func (s *Server) startSession(c *gin.Context, user db.User) {
+	session := sessions.Default(c)
+	session.Set(sessionRotateKey, true)
+	session.Set("user", user.ID)
+	session.Set("generation", user.SessionGeneration)
+	if err := session.Save(); err != nil {
+		log.Printf("Failed to save session for user %d: %s\n", user.ID, err)
+		return
+	}
+
+	s.registerSession(c, session.ID(), user.ID)
+}
+
+// endSession logs the current session out and removes it from redis
+func (s *Server) endSession(c *gin.Context) {
+	session := sessions.Default(c)
+
+	if userID, err := getCurrentUserFrom(session); err == nil {
+		if err := s.revokeSession(userID, session.ID()); err != nil && err != errUnknownSession {
+			log.Printf("Failed to revoke session for user %d: %s\n", userID, err)
+		}
+	}
+
+	session.Clear()
+	session.Options(sessions.Options{Path: "/", MaxAge: -1})
+	session.Save()
+}
+
+// registerSession adds a session which was just logged in to the user's devices
+func (s *Server) registerSession(c *gin.Context, id string, userID int) {
+	if id == "" {
+		return
+	}
+
+	ttl := defaultSessionTTL
+	now := time.Now().Unix()
+
+	pipe := s.redis.TxPipeline()
+	pipe.HMSet(sessionMetaKey(id), map[string]interface{}{
+		"created": now,
+		"ip":      c.ClientIP(),
+		"agent":   c.Request.UserAgent(),
+		"seen":    now,
+	})
+	pipe.Expire(sessionMetaKey(id), ttl)
+	pipe.SAdd(userSessionsKey(userID), id)
+	pipe.Expire(userSessionsKey(userID), ttl)
+	pipe.Set(sessionSeenKey(id), 1, sessionSeenInterval)
+
+	if _, err := pipe.Exec(); err != nil {
+		log.Printf("Failed to register session for user %d: %s\n", userID, err)
+	}
+
+	c.Set(sessionTouchedKey, true)
+}
+
+// touchSession records the IP address and user agent a session was last used from.
+// It runs at most once a request and once every sessionSeenInterval per session,
+// and never brings back a session which was revoked or has expired.
+func (s *Server) touchSession(c *gin.Context, id string, userID int) {
+	if id == "" || c.GetBool(sessionTouchedKey) {
+		return
+	}
+	c.Set(sessionTouchedKey, true)
+
+	due, err := s.redis.SetNX(sessionSeenKey(id), 1, sessionSeenInterval).Result()
+	if err != nil {
+		log.Printf("Failed to track session for user %d: %s\n", userID, err)
+		return
+	}
+	if !due {
+		return
+	}
+
+	ttl := defaultSessionTTL
+
+	// Revoking deletes the session key, which makes the transaction fail rather than recreate the device
+	err = s.redis.Watch(func(tx *redis.Tx) error {
+		exists, err := tx.Exists(sessionKey(id)).Result()
+		if err != nil || exists == 0 {
+			return err
+		}
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.HMSet(sessionMetaKey(id), map[string]interface{}{
+				"ip":    c.ClientIP(),
+				"agent": c.Request.UserAgent(),
+				"seen":  time.Now().Unix(),
+			})
+			pipe.Expire(sessionMetaKey(id), ttl)
+			pipe.Expire(sessionKey(id), ttl)
+			pipe.Expire(userSessionsKey(userID), ttl)
+			return nil
+		})
+		return err
+	}, sessionKey(id))
+	if err != nil && err != redis.TxFailedErr {
+		log.Printf("Failed to track session for user %d: %s\n", userID, err)
+	}
+}
+
+// listDevices returns the user's signed in sessions, most recently used first
+func (s *Server) listDevices(userID int, currentID string) ([]*Device, error) {
+	ids, err := s.redis.SMembers(userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []*Device
+	for _, id := range ids {
+		meta, err := s.redis.HGetAll(sessionMetaKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// The session expired or was revoked
+		if len(meta) == 0 {
+			s.redis.SRem(userSessionsKey(userID), id)
+			continue
+		}
+
+		created, _ := strconv.ParseInt(meta["created"], 10, 64)
+		seen, _ := strconv.ParseInt(meta["seen"], 10, 64)
+
+		devices = append(devices, &Device{
+			ID:        id,
+			IP:        meta["ip"],
+			UserAgent: meta["agent"],
+			Created:   humanize.Time(time.Unix(created, 0)),
+			LastSeen:  humanize.Time(time.Unix(seen, 0)),
+			Current:   id == currentID,
+			seen:      seen,
+		})
+	}
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].seen > devices[j].seen })
+
+	return devices, nil
+}
+
+// revokeSession logs one of the user's sessions out
+func (s *Server) revokeSession(userID int, id string) error {
+	member, err := s.redis.SIsMember(userSessionsKey(userID), id).Result()
+	if err != nil {
+		return err
+	}
+
+	if !member {
+		return errUnknownSession
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.Del(sessionKey(id), sessionMetaKey(id), sessionSeenKey(id))
+	pipe.SRem(userSessionsKey(userID), id)
+	_, err = pipe.Exec()
+	return err
+}
+
+// revokeAllSessions logs every session of the user out apart from except
+func (s *Server) revokeAllSessions(userID int, except string) error {
+	ids, err := s.redis.SMembers(userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id == except {
+			continue
+		}
+
+		if err := s.revokeSession(userID, id); err != nil && err != errUnknownSession {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeSession logs out one of the devices on the settings page
+func (s *Server) RevokeSession(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	id := c.PostForm("sessionID")
+	if id == sessions.Default(c).ID() {
+		s.endSession(c)
+		s.renderHome(c, http.StatusOK, gin.H{"Success": "Successfully logged out"})
+		return
+	}
+
+	err = s.revokeSession(user.ID, id)
+	if err == errUnknownSession {
+		s.renderSettings(c, http.StatusNotFound, user, gin.H{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Device logged out"})
+}
+
+// RevokeAllSessions logs out every device apart from the current one
+func (s *Server) RevokeAllSessions(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = s.revokeAllSessions(user.ID, sessions.Default(c).ID())
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Logged out of every other device"})
+}
+
+// PostChangePassword changes the user's password and logs out every other device
+func (s *Server) PostChangePassword(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !s.Validated(user.ID, c.PostForm("currentPassword")) {
+		s.renderSettings(c, http.StatusUnauthorized, user, gin.H{"Error": "Incorrect password"})
+		return
+	}
+
+	password := c.PostForm("newPassword")
+	if password == "" || password != c.PostForm("confirmPassword") {
+		s.renderSettings(c, http.StatusUnprocessableEntity, user, gin.H{"Error": "Passwords do not match"})
+		return
+	}
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = s.DB.ResetUserHash(user.ID, hash)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Keep this device logged in with the new session generation
+	user, err = s.DB.GetUserByID(user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.startSession(c, user)
+
+	err = s.revokeAllSessions(user.ID, sessions.Default(c).ID())
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Password changed and every other device logged out"})
+}
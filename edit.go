@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/audio"
+	"github.com/tardigradio/website/db"
+)
+
+// ownSongParam loads one of user's songs by its public ID
+func (s *Server) ownSongParam(user db.User, publicID string) (db.Song, error) {
+	song, err := s.DB.GetSongByPublicID(publicID)
+	if err == sql.ErrNoRows || (err == nil && song.UserID != user.ID) {
+		return db.Song{}, errAPISongNotFound
+	}
+
+	return song, err
+}
+
+// GetEditSong gets the page for changing one of the current user's songs
+func (s *Server) GetEditSong(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	song, err := s.ownSongParam(user, c.Param("id"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	s.renderEditSong(c, http.StatusOK, user, song, nil)
+}
+
+// PostEditSong changes a song's details and adds a new current version if a file was uploaded
+func (s *Server) PostEditSong(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	song, err := s.ownSongParam(user, c.Param("id"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("songTitle"))
+	description := c.PostForm("songDesc")
+
+	if title == "" {
+		s.renderEditSong(c, http.StatusUnprocessableEntity, user, song, gin.H{"Error": "Enter a title"})
+		return
+	}
+
+	license, err := licenseParam(c)
+	if err != nil {
+		s.renderEditSong(c, statusFor(err), user, song, gin.H{"Error": err.Error()})
+		return
+	}
+
+	access, err := accessParam(c)
+	if err != nil {
+		s.renderEditSong(c, statusFor(err), user, song, gin.H{"Error": err.Error()})
+		return
+	}
+
+	edit := db.SongEdit{
+		Title:          title,
+		Description:    description,
+		License:        license,
+		Access:         access,
+		VersionsPublic: c.PostForm("versionsPublic") != "",
+		VersionNotes:   strings.TrimSpace(c.PostForm("versionNotes")),
+		RemoveCover:    c.PostForm("removeCover") != "",
+	}
+
+	// Everything uploaded is checked before anything is stored, so a bad file leaves the song as it was
+	audioHeader, err := s.versionFile(c)
+	if err != nil {
+		s.renderEditSongError(c, user, song, err)
+		return
+	}
+
+	var upload multipart.File
+	var info audio.Info
+	var audioSize int64
+	if audioHeader != nil {
+		upload, err = audioHeader.Open()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer upload.Close()
+
+		info, err = probeUpload(upload, audioHeader.Size)
+		if err != nil {
+			s.renderEditSongError(c, user, song, err)
+			return
+		}
+		audioSize = audioHeader.Size
+	}
+
+	var cover *preparedImage
+	var coverSize int64
+	if !edit.RemoveCover {
+		cover, err = preparedImageParam(c, "cover")
+		if err != nil {
+			s.renderEditSongError(c, user, song, err)
+			return
+		}
+		if cover != nil {
+			coverSize = cover.Size
+		}
+	}
+
+	release, err := s.reserveQuota(user, audioSize+coverSize)
+	if err != nil {
+		s.renderEditSongError(c, user, song, err)
+		return
+	}
+	defer release()
+
+	if upload != nil {
+		key, err := s.putAudio(c, user, audioHeader.Filename, upload)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		edit.Version = &db.SongFile{Filename: key, Size: audioSize, SongMeta: songMetaFrom(info)}
+	}
+
+	if cover != nil {
+		if err := s.putImage(c, user, cover); err != nil {
+			s.discardEditUploads(c, user, edit.Version, nil)
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		edit.Cover = cover.Original
+		edit.CoverSize = cover.Size
+	}
+
+	replaced, err := s.DB.EditSong(song.ID, user.ID, edit)
+	if err != nil {
+		s.discardEditUploads(c, user, edit.Version, cover)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if replaced.ID != 0 {
+		if err := s.deleteImageObjects(c, user, replaced.Original); err != nil {
+			log.Printf("Failed to delete replaced image %s: %s\n", replaced.PublicID, err)
+		}
+	}
+
+	song, err = s.DB.GetSong(song.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, songURL(user.Username, song))
+}
+
+// versionFile returns the file uploaded as a new version of a song, or nil if none was uploaded
+func (s *Server) versionFile(c *gin.Context) (*multipart.FileHeader, error) {
+	fileHeader, err := s.uploadedFile(c)
+	if err == errFileRequired {
+		return nil, nil
+	}
+
+	return fileHeader, err
+}
+
+// discardEditUploads deletes the files stored for an edit which could not be saved
+func (s *Server) discardEditUploads(ctx context.Context, user db.User, version *db.SongFile, cover *preparedImage) {
+	if version != nil {
+		s.discardAudio(ctx, user, version.Filename)
+	}
+	if cover != nil {
+		s.discardImageObjects(ctx, user, cover.Original)
+	}
+}
+
+// renderEditSongError shows a problem with an edit on the edit page, or fails with a 500 if it was not the user's fault
+func (s *Server) renderEditSongError(c *gin.Context, user db.User, song db.Song, err error) {
+	if apiErr, ok := err.(*apiError); ok {
+		s.renderEditSong(c, apiErr.Status, user, song, gin.H{"Error": apiErr.Message})
+		return
+	}
+
+	c.String(http.StatusInternalServerError, err.Error())
+}
+
+// renderEditSong renders edit.tmpl with extra variables such as Error
+func (s *Server) renderEditSong(c *gin.Context, status int, user db.User, song db.Song, extra gin.H) {
+	vars, err := s.storageVariables(user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	versions, err := s.versionsWithMeta(user, song)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	vars["currentUser"] = user.Username
+	vars["song"] = song
+	vars["songURL"] = songURL(user.Username, song)
+	vars["coverURL"] = imageURL(song.CoverID, "small")
+	vars["versions"] = versions
+	vars["licenses"] = licenses
+	vars["visibilities"] = visibilities
+	vars["shareURL"] = shareURL(user.Username, song)
+	// Scheduled release times are shown in the artist's timezone by the page
+	if !song.Released(time.Now()) {
+		vars["releaseAt"] = song.ReleaseAt
+	}
+
+	for key, value := range extra {
+		vars[key] = value
+	}
+
+	renderHTML(c, status, "edit.tmpl", vars)
+}
@@ -6,6 +6,26 @@ export STORJAPIKEY="abc123"
 export STORJENCRYPTIONKEY=
 export STORJACCESSKEY=
 export STORJSECRETKEY=
+# Set AUDIOSTORE=local to keep songs on disk instead of the Storj network
+export AUDIOSTORE=
+export AUDIOSTOREPATH=
 export REDISADDR=
 export REDISPASS=
 export SESSIONSECRET=
+# Secret for signing emailed links, defaults to SESSIONSECRET
+export TOKENSECRET=
+# Public address used in emailed links, e.g. https://tardigrad.io
+export SITEURL=
+# MAILER is smtp, file (MAILFILE) or stdout
+export MAILER=
+export MAILFILE=
+export MAILFROM=
+export SMTPADDR=
+export SMTPUSER=
+export SMTPPASS=
+# bcrypt cost for password hashes, defaults to 10
+export PASSWORDCOST=
+# Largest song upload, e.g. 200MB
+export MAXUPLOADSIZE=
+# Storage each user may fill with songs unless they have their own quota, e.g. 2GB
+export STORAGEQUOTA=
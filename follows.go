@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// feedPageSize is how many songs are shown on each page of the home feed
+const feedPageSize = 35
+
+var (
+	errCannotFollowSelf = newAPIError(http.StatusUnprocessableEntity, "You cannot follow yourself")
+	errInvalidCursor    = newAPIError(http.StatusUnprocessableEntity, "Invalid cursor")
+)
+
+// apiProfile is a user with how many users follow them and they follow
+type apiProfile struct {
+	apiUser
+	Followers int  `json:"followers"`
+	Following int  `json:"following"`
+	Followed  bool `json:"followed"` // whether the logged in user follows them
+}
+
+// formatFeedCursor encodes where a page of the feed ended for the next page's link, or "" if it was the last page
+func formatFeedCursor(cursor db.FeedCursor) string {
+	if cursor == (db.FeedCursor{}) {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%d", cursor.Published, cursor.SongID)
+}
+
+// parseFeedCursor decodes a cursor made by formatFeedCursor. "" is the start of the feed.
+func parseFeedCursor(value string) (db.FeedCursor, error) {
+	var cursor db.FeedCursor
+	if value == "" {
+		return cursor, nil
+	}
+
+	if _, err := fmt.Sscanf(value, "%d.%d", &cursor.Published, &cursor.SongID); err != nil || cursor.Published <= 0 {
+		return db.FeedCursor{}, errInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// songsWithMeta adds the artist, likes and comments to songs for index.tmpl
+func (s *Server) songsWithMeta(songs []db.Song) ([]*SongWithMeta, error) {
+	var result []*SongWithMeta
+
+	for _, song := range songs {
+		artist, err := s.DB.GetUserByID(song.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, &SongWithMeta{
+			Song:     song,
+			Artist:   artist.Username,
+			Created:  humanize.Time(time.Unix(song.Published(), 0)),
+			Likes:    s.DB.RefLikeCount(song.ID, db.SongType),
+			Comments: s.DB.CommentCount(song.ID),
+		})
+	}
+
+	return result, nil
+}
+
+// followParam loads the user named by the :name route parameter for user to follow or unfollow
+func (s *Server) followParam(c *gin.Context, user db.User) (db.User, error) {
+	artist, err := s.DB.GetUserByName(c.Param("name"))
+	if err == sql.ErrNoRows {
+		return db.User{}, errAPIUserNotFound
+	}
+	if err != nil {
+		return db.User{}, err
+	}
+
+	if artist.ID == user.ID {
+		return db.User{}, errCannotFollowSelf
+	}
+
+	return artist, nil
+}
+
+// PostFollow makes the current user follow an artist
+func (s *Server) PostFollow(c *gin.Context) {
+	s.setFollowing(c, true)
+}
+
+// PostUnfollow makes the current user stop following an artist
+func (s *Server) PostUnfollow(c *gin.Context) {
+	s.setFollowing(c, false)
+}
+
+// setFollowing follows or unfollows the artist named in the route and goes back to their page
+func (s *Server) setFollowing(c *gin.Context, follow bool) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	artist, err := s.followParam(c, user)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if follow {
+		err = s.DB.Follow(user.ID, artist.ID)
+	} else {
+		err = s.DB.Unfollow(user.ID, artist.ID)
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/user/"+url.PathEscape(artist.Username))
+}
+
+// APIPutFollow makes the logged in user follow an artist
+func (s *Server) APIPutFollow(c *gin.Context) {
+	s.apiSetFollowing(c, true)
+}
+
+// APIDeleteFollow makes the logged in user stop following an artist
+func (s *Server) APIDeleteFollow(c *gin.Context) {
+	s.apiSetFollowing(c, false)
+}
+
+// apiSetFollowing follows or unfollows the artist named in the route and reports their profile
+func (s *Server) apiSetFollowing(c *gin.Context, follow bool) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	artist, err := s.followParam(c, user)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	if follow {
+		err = s.DB.Follow(user.ID, artist.ID)
+	} else {
+		err = s.DB.Unfollow(user.ID, artist.ID)
+	}
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, s.apiProfileFrom(artist, user.ID))
+}
+
+// apiProfileFrom converts a user and their follow counts for the API, as seen by viewerID (0 for guests)
+func (s *Server) apiProfileFrom(user db.User, viewerID int) apiProfile {
+	return apiProfile{
+		apiUser:   apiUser{ID: user.ID, Username: user.Username, Created: user.Created, Avatar: apiAvatarFrom(user)},
+		Followers: s.DB.FollowerCount(user.ID),
+		Following: s.DB.FollowingCount(user.ID),
+		Followed:  viewerID != 0 && s.DB.IsFollowing(viewerID, user.ID),
+	}
+}
+
+// APIGetFeed returns a page of new songs from the artists the logged in user follows.
+// The nextCursor in the response is passed as the cursor query parameter to get the following page.
+func (s *Server) APIGetFeed(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	cursor, err := parseFeedCursor(c.Query("cursor"))
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	feed, next, err := s.DB.GetFeed(user.ID, cursor, feedPageSize)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	songs := []apiSong{}
+	for _, song := range feed {
+		artist, err := s.DB.GetUserByID(song.UserID)
+		if err != nil {
+			apiAbort(c, err)
+			return
+		}
+
+		songs = append(songs, s.apiSongFrom(song, artist.Username))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"songs": songs, "nextCursor": formatFeedCursor(next)})
+}
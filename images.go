@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	// Registers the formats image.Decode accepts
+	_ "image/gif"
+	_ "image/png"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+const (
+	// maxImageSize is the largest image file which can be uploaded
+	maxImageSize = 10 * 1000 * 1000
+	// maxImageSide stops images which would take too much memory to decode
+	maxImageSide = 4000
+	// thumbnailQuality is the JPEG quality thumbnails are encoded with
+	thumbnailQuality = 85
+	// imageCacheControl lets browsers keep thumbnails for good, a new upload always gets a new ID
+	imageCacheControl = "public, max-age=31536000, immutable"
+)
+
+var (
+	errImageNotFound    = newAPIError(http.StatusNotFound, "Image not found")
+	errUnsupportedImage = newAPIError(http.StatusUnsupportedMediaType, "Images can be JPEG, PNG or GIF")
+	errImageTooLarge    = newAPIError(http.StatusRequestEntityTooLarge, "Images can be at most "+humanize.Bytes(maxImageSize))
+	errImageDimensions  = newAPIError(http.StatusUnprocessableEntity, fmt.Sprintf("Images can be at most %d by %d pixels", maxImageSide, maxImageSide))
+	errImageRequired    = newAPIError(http.StatusUnprocessableEntity, "Choose an image")
+)
+
+// imageSize is one of the square thumbnails made of every uploaded image
+type imageSize struct {
+	Name   string
+	Pixels int
+}
+
+// imageSizes are the thumbnails made of every image, by the name used in their URLs
+var imageSizes = []imageSize{
+	{Name: "small", Pixels: 64},
+	{Name: "medium", Pixels: 300},
+	{Name: "large", Pixels: 1000},
+}
+
+// originalExtensions are the extensions originals are stored with, by the format image.Decode reports
+var originalExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+// imageSizeByName looks up a thumbnail size by the name used in URLs
+func imageSizeByName(name string) (imageSize, bool) {
+	for _, size := range imageSizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+
+	return imageSize{}, false
+}
+
+// imageURL returns the path of a thumbnail of an image, or "" if there is no image
+func imageURL(publicID, size string) string {
+	if publicID == "" {
+		return ""
+	}
+
+	return "/images/" + url.PathEscape(publicID) + "/" + size
+}
+
+// gravatarURL returns the Gravatar of an email address, an identicon if they have not set one
+func gravatarURL(email string, pixels int) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return fmt.Sprintf("https://www.gravatar.com/avatar/%x?s=%d&d=identicon", hash, pixels)
+}
+
+// avatarURL returns a user's profile picture at one of the imageSizes, falling back to their Gravatar
+func avatarURL(user db.User, size string) string {
+	if user.AvatarID != "" {
+		return imageURL(user.AvatarID, size)
+	}
+
+	thumbnail, _ := imageSizeByName(size)
+	return gravatarURL(user.Email, thumbnail.Pixels)
+}
+
+// apiImageFrom lists the URL of every thumbnail of an image for the API, or nil if there is no image
+func apiImageFrom(publicID string) map[string]string {
+	if publicID == "" {
+		return nil
+	}
+
+	urls := make(map[string]string, len(imageSizes))
+	for _, size := range imageSizes {
+		urls[size.Name] = imageURL(publicID, size.Name)
+	}
+
+	return urls
+}
+
+// apiAvatarFrom lists the URL of every size of a user's profile picture for the API
+func apiAvatarFrom(user db.User) map[string]string {
+	urls := make(map[string]string, len(imageSizes))
+	for _, size := range imageSizes {
+		urls[size.Name] = avatarURL(user, size.Name)
+	}
+
+	return urls
+}
+
+// thumbnailKey returns the object key of a thumbnail, stored next to its original
+func thumbnailKey(original string, size imageSize) string {
+	return path.Join(path.Dir(original), size.Name+".jpg")
+}
+
+// imageParam returns the image uploaded in a multipart form field, or nil if none was chosen
+func imageParam(c *gin.Context, field string) (*multipart.FileHeader, error) {
+	header, err := c.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errUnsupportedImage
+	}
+
+	if header.Size > maxImageSize {
+		return nil, errImageTooLarge
+	}
+
+	return header, nil
+}
+
+// decodeImage reads and decodes an image, checking it is in a format we accept and small enough to decode
+func decodeImage(file io.Reader) ([]byte, image.Image, string, error) {
+	data, err := io.ReadAll(io.LimitReader(file, maxImageSize+1))
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if len(data) > maxImageSize {
+		return nil, nil, "", errImageTooLarge
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, "", errUnsupportedImage
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxImageSide || config.Height > maxImageSide {
+		return nil, nil, "", errImageDimensions
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, "", errUnsupportedImage
+	}
+
+	return data, decoded, format, nil
+}
+
+// thumbnail crops the middle square out of an image and scales it down to at most pixels wide.
+// Transparent areas are filled with white since thumbnails are JPEGs.
+func thumbnail(src image.Image, pixels int) *image.RGBA {
+	bounds := src.Bounds()
+
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+
+	// Images are never scaled up
+	target := pixels
+	if side < target {
+		target = side
+	}
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+	draw.Draw(square, square.Bounds(), src, offset, draw.Over)
+
+	if target == side {
+		return square
+	}
+
+	// Each thumbnail pixel is the average of the block of pixels it covers
+	dst := image.NewRGBA(image.Rect(0, 0, target, target))
+	for y := 0; y < target; y++ {
+		y0, y1 := y*side/target, (y+1)*side/target
+		for x := 0; x < target; x++ {
+			x0, x1 := x*side/target, (x+1)*side/target
+
+			var r, g, b, a, count int
+			for sy := y0; sy < y1; sy++ {
+				row := square.Pix[sy*square.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					r += int(pixel[0])
+					g += int(pixel[1])
+					b += int(pixel[2])
+					a += int(pixel[3])
+					count++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / count)
+			dst.Pix[i+1] = uint8(g / count)
+			dst.Pix[i+2] = uint8(b / count)
+			dst.Pix[i+3] = uint8(a / count)
+		}
+	}
+
+	return dst
+}
+
+// encodeThumbnail makes a thumbnail of an image as a JPEG
+func encodeThumbnail(src image.Image, size imageSize) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail(src, size.Pixels), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// preparedImage is an uploaded image and its thumbnails, encoded and ready to be stored
+type preparedImage struct {
+	Original string
+	// Objects are the contents of the original and every thumbnail by object key
+	Objects map[string][]byte
+	Size    int64
+}
+
+// prepareImage decodes an uploaded image and encodes its thumbnails without storing anything
+func prepareImage(header *multipart.FileHeader) (*preparedImage, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, decoded, format, err := decodeImage(file)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := newObjectKey("original" + originalExtensions[format])
+	if err != nil {
+		return nil, err
+	}
+
+	prepared := &preparedImage{
+		Original: original,
+		Objects:  map[string][]byte{original: data},
+		Size:     int64(len(data)),
+	}
+
+	for _, thumbnailSize := range imageSizes {
+		encoded, err := encodeThumbnail(decoded, thumbnailSize)
+		if err != nil {
+			return nil, err
+		}
+
+		prepared.Objects[thumbnailKey(original, thumbnailSize)] = encoded
+		prepared.Size += int64(len(encoded))
+	}
+
+	return prepared, nil
+}
+
+// putImage stores a prepared image in the user's bucket, removing anything it stored if a put fails
+func (s *Server) putImage(ctx context.Context, user db.User, prepared *preparedImage) error {
+	for key, data := range prepared.Objects {
+		if err := s.store.Put(ctx, user.Username, key, bytes.NewReader(data)); err != nil {
+			s.discardImageObjects(ctx, user, prepared.Original)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// preparedImageParam decodes the image uploaded in a multipart form field and encodes its thumbnails, or returns nil if none was chosen
+func preparedImageParam(c *gin.Context, field string) (*preparedImage, error) {
+	header, err := imageParam(c, field)
+	if err != nil || header == nil {
+		return nil, err
+	}
+
+	return prepareImage(header)
+}
+
+// saveImage stores a prepared image in the user's bucket along with its thumbnails and records it
+func (s *Server) saveImage(ctx context.Context, user db.User, prepared *preparedImage) (db.Image, error) {
+	if err := s.putImage(ctx, user, prepared); err != nil {
+		return db.Image{}, err
+	}
+
+	id, err := s.DB.AddImage(user.ID, prepared.Original, prepared.Size)
+	if err != nil {
+		s.discardImageObjects(ctx, user, prepared.Original)
+		return db.Image{}, err
+	}
+
+	return s.DB.GetImage(int(id))
+}
+
+// deleteImage removes one of user's images and its thumbnails. Nothing happens if publicID is "".
+func (s *Server) deleteImage(ctx context.Context, user db.User, publicID string) error {
+	if publicID == "" {
+		return nil
+	}
+
+	picture, err := s.DB.GetImageByPublicID(publicID)
+	if err == sql.ErrNoRows || (err == nil && picture.UserID != user.ID) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.DB.DeleteImage(picture.ID); err != nil {
+		return err
+	}
+
+	return s.deleteImageObjects(ctx, user, picture.Original)
+}
+
+// deleteImageObjects removes an image's original and thumbnails from the user's bucket
+func (s *Server) deleteImageObjects(ctx context.Context, user db.User, original string) error {
+	// Carry on past failures so as few objects as possible are left behind
+	var firstErr error
+	keys := []string{original}
+	for _, size := range imageSizes {
+		keys = append(keys, thumbnailKey(original, size))
+	}
+
+	for _, key := range keys {
+		err := s.store.Delete(ctx, user.Username, key)
+		if err != nil && err != ErrObjectNotFound && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// discardImageObjects removes the objects of an image which never made it into the database, logging any failure
+func (s *Server) discardImageObjects(ctx context.Context, user db.User, original string) {
+	if err := s.deleteImageObjects(ctx, user, original); err != nil {
+		log.Printf("Failed to delete unused image %s: %s\n", original, err)
+	}
+}
+
+// replaceImage stores the image uploaded in field, lets set use it and then deletes the image it replaced.
+// It returns the new image's public ID, or previous if no image was uploaded.
+func (s *Server) replaceImage(c *gin.Context, user db.User, field, previous string, set func(publicID string) error) (string, error) {
+	prepared, err := preparedImageParam(c, field)
+	if err != nil || prepared == nil {
+		return previous, err
+	}
+
+	// Thumbnails count towards the quota along with the original
+	release, err := s.reserveQuota(user, prepared.Size)
+	if err != nil {
+		return previous, err
+	}
+	defer release()
+
+	picture, err := s.saveImage(c, user, prepared)
+	if err != nil {
+		return previous, err
+	}
+
+	if err := set(picture.PublicID); err != nil {
+		if deleteErr := s.deleteImage(c, user, picture.PublicID); deleteErr != nil {
+			log.Printf("Failed to delete unused image %s: %s\n", picture.PublicID, deleteErr)
+		}
+		return previous, err
+	}
+
+	if err := s.deleteImage(c, user, previous); err != nil {
+		log.Printf("Failed to delete replaced image %s: %s\n", previous, err)
+	}
+
+	return picture.PublicID, nil
+}
+
+// makeThumbnail creates a thumbnail which is missing, such as for album covers uploaded before thumbnails were made,
+// and returns it opened for reading
+func (s *Server) makeThumbnail(ctx context.Context, owner db.User, picture db.Image, size imageSize) (AudioObject, ObjectInfo, error) {
+	original, _, err := s.store.Get(ctx, owner.Username, picture.Original)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	defer original.Close()
+
+	_, decoded, _, err := decodeImage(original)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	encoded, err := encodeThumbnail(decoded, size)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	key := thumbnailKey(picture.Original, size)
+	if err := s.store.Put(ctx, owner.Username, key, bytes.NewReader(encoded)); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	if err := s.DB.AddImageSize(picture.ID, int64(len(encoded))); err != nil {
+		log.Printf("Failed to count thumbnail %s/%s towards storage: %s\n", owner.Username, key, err)
+	}
+
+	return s.store.Get(ctx, owner.Username, key)
+}
+
+// GetImage serves a thumbnail of an image, named by the :size route parameter
+func (s *Server) GetImage(c *gin.Context) {
+	size, ok := imageSizeByName(c.Param("size"))
+	if !ok {
+		c.String(errImageNotFound.Status, errImageNotFound.Error())
+		return
+	}
+
+	picture, err := s.DB.GetImageByPublicID(c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.String(errImageNotFound.Status, errImageNotFound.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	owner, err := s.DB.GetUserByID(picture.UserID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	object, info, err := s.store.Get(c, owner.Username, thumbnailKey(picture.Original, size))
+	if err == ErrObjectNotFound {
+		object, info, err = s.makeThumbnail(c, owner, picture, size)
+	}
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+	defer object.Close()
+
+	etag := objectETag(info)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", imageCacheControl)
+	if notModified(c.Request, etag, info.Modified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, info.Size, "image/jpeg", object, nil)
+}
+
+// PostAvatar replaces the current user's profile picture with the uploaded image
+func (s *Server) PostAvatar(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = s.setAvatar(c, user)
+	if apiErr, ok := err.(*apiError); ok {
+		s.renderSettings(c, apiErr.Status, user, gin.H{"Error": apiErr.Message})
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	user, err = s.DB.GetUserByID(user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Profile picture changed"})
+}
+
+// PostDeleteAvatar removes the current user's profile picture, going back to their Gravatar
+func (s *Server) PostDeleteAvatar(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.deleteImage(c, user, user.AvatarID); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	user.AvatarID = ""
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Profile picture removed"})
+}
+
+// setAvatar saves the image in the image field of a multipart form as user's profile picture
+func (s *Server) setAvatar(c *gin.Context, user db.User) error {
+	if err := s.parseUpload(c); err != nil {
+		return err
+	}
+
+	if _, err := c.FormFile("image"); err != nil {
+		return errImageRequired
+	}
+
+	_, err := s.replaceImage(c, user, "image", user.AvatarID, func(publicID string) error {
+		return s.DB.SetAvatar(user.ID, publicID)
+	})
+	return err
+}
+
+// APIPutAvatar replaces the logged in user's profile picture with the image in a multipart form's image field
+func (s *Server) APIPutAvatar(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	if err := s.setAvatar(c, user); err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	user, err = s.DB.GetUserByID(user.ID)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"avatar": apiAvatarFrom(user)})
+}
+
+// APIDeleteAvatar removes the logged in user's profile picture, going back to their Gravatar
+func (s *Server) APIDeleteAvatar(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	if err := s.deleteImage(c, user, user.AvatarID); err != nil {
+		apiAbort(c, err)
+		return
+	}
+	user.AvatarID = ""
+
+	c.JSON(http.StatusOK, gin.H{"avatar": apiAvatarFrom(user)})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// License is one of the licenses an artist can release a song under
+type License struct {
+	ID   string
+	Name string
+	URL  string
+	// Downloadable licenses let listeners keep a copy of the audio
+	Downloadable bool
+}
+
+// defaultLicense is the license songs were released under before artists could choose
+const defaultLicense = "cc-by-nc-sa"
+
+// licenses is the catalog artists choose from, in the order they are offered
+var licenses = []License{
+	{ID: "all-rights-reserved", Name: "All rights reserved"},
+	{ID: "cc-by", Name: "Creative Commons Attribution 4.0", URL: "https://creativecommons.org/licenses/by/4.0/", Downloadable: true},
+	{ID: "cc-by-sa", Name: "Creative Commons Attribution-ShareAlike 4.0", URL: "https://creativecommons.org/licenses/by-sa/4.0/", Downloadable: true},
+	{ID: "cc-by-nd", Name: "Creative Commons Attribution-NoDerivatives 4.0", URL: "https://creativecommons.org/licenses/by-nd/4.0/", Downloadable: true},
+	{ID: "cc-by-nc", Name: "Creative Commons Attribution-NonCommercial 4.0", URL: "https://creativecommons.org/licenses/by-nc/4.0/", Downloadable: true},
+	{ID: "cc-by-nc-sa", Name: "Creative Commons Attribution-NonCommercial-ShareAlike 4.0", URL: "https://creativecommons.org/licenses/by-nc-sa/4.0/", Downloadable: true},
+	{ID: "cc-by-nc-nd", Name: "Creative Commons Attribution-NonCommercial-NoDerivatives 4.0", URL: "https://creativecommons.org/licenses/by-nc-nd/4.0/", Downloadable: true},
+	{ID: "cc0", Name: "CC0 1.0 Public Domain Dedication", URL: "https://creativecommons.org/publicdomain/zero/1.0/", Downloadable: true},
+}
+
+var errUnknownLicense = newAPIError(http.StatusUnprocessableEntity, "Choose a license from the list")
+
+// licenseByID looks up a license in the catalog
+func licenseByID(id string) (License, bool) {
+	for _, license := range licenses {
+		if license.ID == id {
+			return license, true
+		}
+	}
+
+	return License{}, false
+}
+
+// songLicense returns the license a song was released under
+func songLicense(song db.Song) License {
+	if license, ok := licenseByID(song.License); ok {
+		return license
+	}
+
+	license, _ := licenseByID(defaultLicense)
+	return license
+}
+
+// licenseParam reads the license chosen in a form, using the default if none was chosen
+func licenseParam(c *gin.Context) (string, error) {
+	id := c.PostForm("license")
+	if id == "" {
+		return defaultLicense, nil
+	}
+
+	if _, ok := licenseByID(id); !ok {
+		return "", errUnknownLicense
+	}
+
+	return id, nil
+}
+
+// canDownload reports whether the current user may download a song's audio.
+// Artists can always download their own songs.
+func (s *Server) canDownload(c *gin.Context, song db.Song) bool {
+	if songLicense(song).Downloadable {
+		return true
+	}
+
+	user, err := s.getCurrentUserFromDbBy(c)
+	return err == nil && user.ID == song.UserID
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer sends plain text emails to users
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// openMailer selects the Mailer from the MAILER environment variable.
+// "smtp" sends through SMTPADDR, "file" appends to MAILFILE and anything else prints to stdout.
+func openMailer() (Mailer, error) {
+	from := os.Getenv("MAILFROM")
+	if from == "" {
+		from = "noreply@tardigrad.io"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		addr := os.Getenv("SMTPADDR")
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTPADDR: %s", err)
+		}
+
+		var auth smtp.Auth
+		if user := os.Getenv("SMTPUSER"); user != "" {
+			auth = smtp.PlainAuth("", user, os.Getenv("SMTPPASS"), host)
+		}
+
+		return &smtpMailer{addr: addr, from: from, auth: auth}, nil
+	case "file":
+		file, err := os.OpenFile(os.Getenv("MAILFILE"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		return &writerMailer{w: file, from: from}, nil
+	case "", "stdout":
+		return &writerMailer{w: os.Stdout, from: from}, nil
+	default:
+		return nil, errors.New("unknown MAILER: " + os.Getenv("MAILER"))
+	}
+}
+
+// smtpMailer sends email through an SMTP server
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// Send an email through the SMTP server
+func (m *smtpMailer) Send(to, subject, body string) error {
+	msg, err := formatMessage(m.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, msg)
+}
+
+// writerMailer writes emails to a file or stdout for local development
+type writerMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+// Send writes the email to the writer
+func (m *writerMailer) Send(to, subject, body string) error {
+	msg, err := formatMessage(m.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.w, "%s\r\n\r\n", msg)
+	return err
+}
+
+// formatMessage builds an RFC 5322 message, refusing header injection through to or subject
+func formatMessage(from, to, subject, body string) ([]byte, error) {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return nil, errors.New("invalid email header")
+	}
+
+	headers := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body), nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,20 +11,29 @@ import (
 
 	iplimiter "github.com/Salvatore-Giordano/gin-redis-ip-limiter"
 	"github.com/alicebob/miniredis"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
 )
 
 // AuthRequired is a handler requires users to be logged in for access to specific routes
+// Scripts may authenticate with an API token in the Authorization: Bearer header instead.
 func AuthRequired(server *Server) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		user := session.Get("user")
+		if usedToken, err := server.authenticateToken(c); usedToken {
+			if err != nil {
+				apiAbort(c, err)
+				return
+			}
 
-		if user == nil {
+			c.Next()
+			return
+		}
+
+		_, err := server.getCurrentUserFromDbBy(c)
+
+		if err != nil {
 			// You'd normally redirect to login page
-			c.HTML(http.StatusBadRequest, "login.tmpl", gin.H{
+			renderHTML(c, http.StatusBadRequest, "login.tmpl", gin.H{
 				"Error": "Invalid session token",
 			})
 			c.Abort()
@@ -38,11 +48,9 @@ func AuthRequired(server *Server) gin.HandlerFunc {
 // GuestRequired is a handler requires users to be logged out for access to specific routes
 func GuestRequired(server *Server) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		userID, err := getCurrentUserFrom(session)
+		user, err := server.getCurrentUserFromDbBy(c)
 		if err == nil {
-			user, _ := server.DB.GetUserByID(userID)
-			c.HTML(http.StatusBadRequest, "index.tmpl", gin.H{
+			renderHTML(c, http.StatusBadRequest, "index.tmpl", gin.H{
 				"Error":       "You are already logged in",
 				"currentUser": user.Username,
 			})
@@ -66,10 +74,21 @@ func main() {
 	ctx := context.Background()
 	port := "8080"
 
+	pendingMigrations := flag.Bool("pending-migrations", false, "list the database migrations which have not been applied yet and exit")
+	flag.Parse()
+
+	if *pendingMigrations {
+		if err := printPendingMigrations(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Determine port to run server at from command line arguments
-	if len(os.Args) > 1 {
-		if matched, _ := regexp.MatchString(`^\d{2,6}$`, os.Args[1]); matched == true {
-			port = os.Args[1]
+	if flag.NArg() > 0 {
+		if matched, _ := regexp.MatchString(`^\d{2,6}$`, flag.Arg(0)); matched == true {
+			port = flag.Arg(0)
 		}
 	}
 
@@ -98,10 +117,15 @@ func main() {
 	})
 
 	// Initialize the Server Struct
-	server := Initialize(ctx)
+	server := Initialize(ctx, rc)
 	defer server.Close() // Cleanly shutdown server
 
+	// Finish purging buckets of deleted accounts which failed earlier
+	go server.RunPurges(ctx)
+
 	server.r.Use(iplimiter.NewRateLimiterMiddleware(rc, "general", 200, 60*time.Second))
+	server.r.Use(BodyLimit(server))
+	server.r.Use(CSRFProtection())
 
 	// Homepage
 	server.r.GET("/", server.GetRoot)
@@ -114,19 +138,59 @@ func main() {
 	private := server.r.Group("/active")
 	private.Use(AuthRequired(server))
 	{
-		private.GET("/logout", server.GetLogout)
-		private.GET("/settings", server.GetSettings)
-		private.GET("/upload", server.GetUpload)
-		private.POST("/upload", server.PostUpload)
-		private.POST("/delete", server.DeleteUser)
+		private.GET("/logout", SessionRequired(), server.GetLogout)
+		private.GET("/settings", SessionRequired(), server.GetSettings)
+		private.GET("/upload", RequireScope(scopeRead), VerifiedRequired(server), server.GetUpload)
+		private.POST("/upload", RequireScope(scopeUpload), VerifiedRequired(server), server.PostUpload)
+		private.POST("/delete", SessionRequired(), server.DeleteUser)
+		private.GET("/songs/:id/edit", SessionRequired(), server.GetEditSong)
+		private.POST("/songs/:id/edit", SessionRequired(), server.PostEditSong)
+		private.POST("/songs/:id/delete", SessionRequired(), server.DeleteSong)
+		private.POST("/songs/:id/playlists", SessionRequired(), server.PostSongToPlaylist)
+		private.POST("/songs/:id/album", SessionRequired(), server.PostSongAlbum)
+		private.POST("/songs/:id/versions/:number/current", SessionRequired(), server.PostCurrentVersion)
+		private.POST("/songs/:id/versions/:number/delete", SessionRequired(), server.PostDeleteVersion)
+		private.GET("/albums/new", RequireScope(scopeRead), VerifiedRequired(server), server.GetNewAlbum)
+		private.POST("/albums", RequireScope(scopeUpload), VerifiedRequired(server), server.PostAlbum)
+		private.POST("/albums/:id/edit", SessionRequired(), server.PostEditAlbum)
+		private.POST("/albums/:id/delete", SessionRequired(), server.PostDeleteAlbum)
+		private.POST("/albums/:id/tracks/remove", SessionRequired(), server.PostRemoveAlbumTrack)
+		private.POST("/albums/:id/order", SessionRequired(), server.PostAlbumOrder)
+		private.POST("/playlists", SessionRequired(), server.PostPlaylist)
+		private.POST("/playlists/:id/edit", SessionRequired(), server.PostEditPlaylist)
+		private.POST("/playlists/:id/delete", SessionRequired(), server.PostDeletePlaylist)
+		private.POST("/playlists/:id/songs/remove", SessionRequired(), server.PostRemovePlaylistSong)
+		private.POST("/playlists/:id/order", SessionRequired(), server.PostPlaylistOrder)
+		private.POST("/playlists/:id/collaborators", SessionRequired(), server.PostPlaylistCollaborator)
+		private.POST("/playlists/:id/collaborators/remove", SessionRequired(), server.PostRemovePlaylistCollaborator)
+		private.POST("/users/:name/follow", SessionRequired(), server.PostFollow)
+		private.POST("/users/:name/unfollow", SessionRequired(), server.PostUnfollow)
+		private.POST("/comment", SessionRequired(), server.PostComment)
+		private.POST("/comment/delete", RequireScope(scopeDelete), server.DeleteComment)
+		private.POST("/tokens", SessionRequired(), server.PostToken)
+		private.POST("/tokens/revoke", SessionRequired(), server.RevokeToken)
+		private.POST("/verify/resend", SessionRequired(), server.PostResendVerification)
+		private.POST("/2fa/setup", SessionRequired(), server.PostTwoFactorSetup)
+		private.POST("/2fa/enable", SessionRequired(), server.PostTwoFactorEnable)
+		private.POST("/2fa/disable", SessionRequired(), server.PostTwoFactorDisable)
+		private.POST("/password", SessionRequired(), server.PostChangePassword)
+		private.POST("/avatar", RequireScope(scopeUpload), VerifiedRequired(server), server.PostAvatar)
+		private.POST("/avatar/delete", SessionRequired(), server.PostDeleteAvatar)
+		private.POST("/sessions/revoke", SessionRequired(), server.RevokeSession)
+		private.POST("/sessions/revoke-all", SessionRequired(), server.RevokeAllSessions)
 	}
 
 	// Public routes for user pages
 	server.r.GET("/user/:name", server.GetUser)
-	server.r.GET("/user/:name/*song", server.GetSong)
-	server.r.POST("/user/:name/*song", server.DownloadSong)
+	server.r.GET("/user/:name/*song", server.GetSongPath)
 	server.r.GET("/download/:name/*song", server.DownloadSong)
-	server.r.POST("/delete/*song", server.DeleteSong)
+	server.r.GET("/s/:id", server.GetSongPermalink)
+	server.r.GET("/p/:id", server.GetPlaylist)
+	server.r.GET("/a/:id", server.GetAlbum)
+	server.r.GET("/a/:id/cover", server.GetAlbumCover)
+	server.r.GET("/images/:id/:size", server.GetImage)
+	server.r.GET("/verify", server.GetVerify)
+	server.r.GET("/search", server.GetSearch)
 
 	// Rate limited routes
 	like := server.r.Group("/like")
@@ -134,8 +198,52 @@ func main() {
 	like.Use(RateLimit())
 	{
 		like.POST("/", server.ToggleLike)
-		like.POST("/count", server.GetLikeCount)
-		like.POST("/status", server.IsLiked)
+		like.GET("/count", server.GetLikeCount)
+		like.GET("/status", server.IsLiked)
+	}
+
+	// JSON API for the mobile client
+	api := server.r.Group("/api/v1")
+	api.Use(APIAuthOptional(server), RequireScope(scopeRead))
+	{
+		api.GET("/users/:name", server.APIGetUser)
+		api.GET("/users/:name/songs", server.APIGetUserSongs)
+		api.GET("/users/:name/playlists", server.APIGetUserPlaylists)
+		api.GET("/users/:name/albums", server.APIGetUserAlbums)
+		api.GET("/albums/:id", server.APIGetAlbum)
+		api.GET("/playlists/:id", server.APIGetPlaylist)
+		api.GET("/songs", server.APIGetRecentSongs)
+		api.GET("/search", server.APISearch)
+		api.GET("/songs/:id", server.APIGetSong)
+		api.GET("/songs/:id/audio", server.APIGetSongAudio)
+		api.GET("/songs/:id/comments", server.APIGetComments)
+		api.GET("/songs/:id/likes", server.APIGetSongLikes)
+		api.GET("/comments/:id/likes", server.APIGetCommentLikes)
+	}
+
+	// JSON API routes that require users to be logged in
+	apiPrivate := server.r.Group("/api/v1")
+	apiPrivate.Use(APIAuthRequired(server))
+	{
+		apiPrivate.GET("/me", RequireScope(scopeRead), server.APIGetMe)
+		apiPrivate.PUT("/me/avatar", RequireScope(scopeUpload), VerifiedRequired(server), server.APIPutAvatar)
+		apiPrivate.DELETE("/me/avatar", RequireScope(scopeDelete), server.APIDeleteAvatar)
+		apiPrivate.GET("/feed", RequireScope(scopeRead), server.APIGetFeed)
+		apiPrivate.POST("/albums", RequireScope(scopeUpload), VerifiedRequired(server), server.APIPostAlbum)
+		apiPrivate.POST("/playlists", SessionRequired(), server.APIPostPlaylist)
+		apiPrivate.PUT("/playlists/:id/songs/:songID", SessionRequired(), server.APIPutPlaylistSong)
+		apiPrivate.DELETE("/playlists/:id/songs/:songID", SessionRequired(), server.APIDeletePlaylistSong)
+		apiPrivate.PUT("/playlists/:id/order", SessionRequired(), server.APIPutPlaylistOrder)
+		apiPrivate.PUT("/users/:name/follow", SessionRequired(), server.APIPutFollow)
+		apiPrivate.DELETE("/users/:name/follow", SessionRequired(), server.APIDeleteFollow)
+		apiPrivate.POST("/songs", RequireScope(scopeUpload), VerifiedRequired(server), server.APIPostSong)
+		apiPrivate.DELETE("/songs/:id", RequireScope(scopeDelete), server.APIDeleteSong)
+		apiPrivate.POST("/songs/:id/comments", SessionRequired(), server.APIPostComment)
+		apiPrivate.PUT("/songs/:id/like", SessionRequired(), server.APIPutSongLike)
+		apiPrivate.DELETE("/songs/:id/like", SessionRequired(), server.APIDeleteSongLike)
+		apiPrivate.DELETE("/comments/:id", RequireScope(scopeDelete), server.APIDeleteComment)
+		apiPrivate.PUT("/comments/:id/like", SessionRequired(), server.APIPutCommentLike)
+		apiPrivate.DELETE("/comments/:id/like", SessionRequired(), server.APIDeleteCommentLike)
 	}
 
 	// Routes that are only accessible if not logged in
@@ -146,6 +254,12 @@ func main() {
 		guest.POST("/register", server.PostRegister)
 		guest.GET("/login", server.GetLogin)
 		guest.POST("/login", server.PostLogin)
+		guest.GET("/login/2fa", server.GetTwoFactorLogin)
+		guest.POST("/login/2fa", server.PostTwoFactorLogin)
+		guest.GET("/forgot", server.GetForgot)
+		guest.POST("/forgot", iplimiter.NewRateLimiterMiddleware(rc, "forgot", 10, time.Hour), server.PostForgot)
+		guest.GET("/reset", server.GetReset)
+		guest.POST("/reset", server.PostReset)
 	}
 
 	server.Run(fmt.Sprintf(":%s", port))
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"crypto/subtle"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCostFromEnv reads the bcrypt cost from PASSWORDCOST, falling back to bcrypt.DefaultCost
+func passwordCostFromEnv() int {
+	cost, err := strconv.Atoi(os.Getenv("PASSWORDCOST"))
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+
+	return cost
+}
+
+// hashPassword hashes a password with bcrypt.
+// The cost and salt are encoded in the returned hash.
+func (s *Server) hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), s.passwordCost)
+}
+
+// checkPassword compares a password against a stored bcrypt or legacy sha512 hash
+func checkPassword(hash []byte, password string) bool {
+	if isLegacyHash(hash) {
+		return subtle.ConstantTimeCompare(hash, getHashFrom([]byte(password))) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// needsRehash reports whether a stored hash is legacy sha512 or uses a different cost than configured
+func (s *Server) needsRehash(hash []byte) bool {
+	if isLegacyHash(hash) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != s.passwordCost
+}
+
+// rehashPassword upgrades a user's stored hash after they have logged in with password
+func (s *Server) rehashPassword(userID int, password string) error {
+	userhash, err := s.DB.GetUserHash(userID)
+	if err != nil {
+		return err
+	}
+
+	if !s.needsRehash(userhash) {
+		return nil
+	}
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.SetUserHash(userID, hash)
+}
+
+// isLegacyHash detects unsalted sha512 hashes created before bcrypt was used
+func isLegacyHash(hash []byte) bool {
+	return len(hash) == sha512.Size && !bytes.HasPrefix(hash, []byte("$2"))
+}
+
+// getHashFrom will sha512 hash a byte slice.
+// It is only used to verify legacy hashes.
+func getHashFrom(salt []byte) []byte {
+	h := sha512.New()
+	h.Write(salt)
+	return h.Sum(nil)
+}
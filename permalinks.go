@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// songURL returns the path of a song's page
+func songURL(artist string, song db.Song) string {
+	return "/user/" + url.PathEscape(artist) + "/s/" + url.PathEscape(song.Slug)
+}
+
+// songAudioURL returns the path a song's audio is streamed from
+func songAudioURL(artist string, song db.Song) string {
+	return songURL(artist, song) + "/audio"
+}
+
+// parseSongPath splits the *song parameter of /s/:slug, /s/:slug/audio and /s/:slug/v/:number/audio paths.
+// version is 0 unless the path names one.
+func parseSongPath(path string) (slug string, version int, audio bool, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "s" || parts[1] == "" {
+		return "", 0, false, false
+	}
+
+	slug = parts[1]
+	switch {
+	case len(parts) == 2:
+		return slug, 0, false, true
+	case len(parts) == 3 && parts[2] == "audio":
+		return slug, 0, true, true
+	case len(parts) == 5 && parts[2] == "v" && parts[4] == "audio":
+		version, err := strconv.Atoi(parts[3])
+		if err != nil || version < 1 {
+			return "", 0, false, false
+		}
+		return slug, version, true, true
+	}
+
+	return "", 0, false, false
+}
+
+// GetSongPath will Get the "/user/:name/*song" endpoint.
+// Songs are found at /user/:name/s/:slug, their audio at /user/:name/s/:slug/audio and
+// older versions at /user/:name/s/:slug/v/:number/audio.
+// Any other path is taken to be the title a song used to be linked by.
+func (s *Server) GetSongPath(c *gin.Context) {
+	user, err := s.DB.GetUserByName(c.Param("name"))
+	if err == sql.ErrNoRows {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	slug, version, audio, ok := parseSongPath(c.Param("song"))
+	if !ok {
+		s.redirectLegacySong(c, user, songURL)
+		return
+	}
+
+	song, moved, err := s.DB.GetSongBySlug(user.ID, slug)
+	if err == sql.ErrNoRows || (err == nil && !s.canViewSong(c, song)) {
+		c.String(http.StatusNotFound, "Song not found")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Old slugs of retitled songs move to the current one
+	if moved {
+		location := songURL(user.Username, song)
+		switch {
+		case version > 0:
+			location = songVersionAudioURL(user.Username, song, version)
+		case audio:
+			location = songAudioURL(user.Username, song)
+		}
+
+		c.Redirect(http.StatusMovedPermanently, withShareKey(c, song, location))
+		return
+	}
+
+	if version > 0 {
+		s.serveSongVersion(c, user, song, version)
+		return
+	}
+
+	if audio {
+		s.serveSong(c, user.Username, song)
+		return
+	}
+
+	s.renderSong(c, user, song)
+}
+
+// DownloadSong will Get the legacy "/download/:name/*song" endpoint,
+// redirecting to where the song's audio is now streamed from
+func (s *Server) DownloadSong(c *gin.Context) {
+	user, err := s.DB.GetUserByName(c.Param("name"))
+	if err == sql.ErrNoRows {
+		c.String(http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.redirectLegacySong(c, user, songAudioURL)
+}
+
+// redirectLegacySong permanently redirects a link made from a song's title to the location built by to
+func (s *Server) redirectLegacySong(c *gin.Context, user db.User, to func(string, db.Song) string) {
+	title := strings.TrimPrefix(c.Param("song"), "/")
+
+	song, err := s.DB.GetSongByNameForUser(title, user.ID)
+	if err == sql.ErrNoRows || (err == nil && !s.canViewSong(c, song)) {
+		c.String(http.StatusNotFound, "Song not found")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusMovedPermanently, withShareKey(c, song, to(user.Username, song)))
+}
+
+// GetSongPermalink will Get the "/s/:id" endpoint, redirecting a song's public ID to its page
+func (s *Server) GetSongPermalink(c *gin.Context) {
+	song, err := s.DB.GetSongByPublicID(c.Param("id"))
+	if err == sql.ErrNoRows || (err == nil && !s.canViewSong(c, song)) {
+		c.String(http.StatusNotFound, "Song not found")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	artist, err := s.DB.GetUserByID(song.UserID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The slug may change, so browsers should not remember where this went
+	c.Redirect(http.StatusFound, withShareKey(c, song, songURL(artist.Username, song)))
+}
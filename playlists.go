@@ -0,0 +1,688 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+var (
+	errPlaylistNotFound      = newAPIError(http.StatusNotFound, "Playlist not found")
+	errPlaylistTitleRequired = newAPIError(http.StatusUnprocessableEntity, "Enter a title for the playlist")
+	errPlaylistForbidden     = newAPIError(http.StatusForbidden, "You cannot change this playlist")
+	errNotInPlaylist         = newAPIError(http.StatusUnprocessableEntity, "The song is not in the playlist")
+)
+
+// PlaylistEntryWithMeta contains a song in a playlist and where it is played from for playlist.tmpl
+type PlaylistEntryWithMeta struct {
+	db.PlaylistEntry
+	URL      string
+	AudioURL string
+}
+
+// apiPlaylist is the JSON representation of a playlist
+type apiPlaylist struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Owner       string    `json:"owner"`
+	Visibility  string    `json:"visibility"`
+	URL         string    `json:"url"`
+	Created     int       `json:"created"`
+	Updated     int       `json:"updated"`
+	Songs       []apiSong `json:"songs,omitempty"`
+}
+
+// playlistAccess is what a user may do with a playlist
+type playlistAccess struct {
+	View bool
+	Edit bool // add, remove and reorder songs
+	Own  bool // also change the details, collaborators and delete it
+}
+
+// playlistURL returns the path of a playlist's page
+func playlistURL(playlist db.Playlist) string {
+	return "/p/" + url.PathEscape(playlist.PublicID)
+}
+
+// playlistShareURL returns the secret link to an unlisted playlist
+func playlistShareURL(playlist db.Playlist) string {
+	return playlistURL(playlist) + "?key=" + url.QueryEscape(playlist.ShareToken)
+}
+
+// playlistAccessFor works out what a user (ID 0 for guests) may do with a playlist reached with key.
+// Owners and collaborators see it whatever its visibility.
+func (s *Server) playlistAccessFor(userID int, playlist db.Playlist, key string) playlistAccess {
+	var access playlistAccess
+
+	access.Own = userID != 0 && userID == playlist.UserID
+	access.Edit = access.Own || (userID != 0 && s.DB.IsPlaylistCollaborator(playlist.ID, userID))
+
+	switch {
+	case access.Edit, playlist.Visibility == db.VisibilityPublic:
+		access.View = true
+	case playlist.Visibility == db.VisibilityUnlisted:
+		access.View = key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(playlist.ShareToken)) == 1
+	}
+
+	return access
+}
+
+// playlistParam loads the playlist in the :id route parameter which user may see, and what they may do with it
+func (s *Server) playlistParam(c *gin.Context, user db.User) (db.Playlist, playlistAccess, error) {
+	playlist, err := s.DB.GetPlaylistByPublicID(c.Param("id"))
+	if err == sql.ErrNoRows {
+		return db.Playlist{}, playlistAccess{}, errPlaylistNotFound
+	}
+	if err != nil {
+		return db.Playlist{}, playlistAccess{}, err
+	}
+
+	access := s.playlistAccessFor(user.ID, playlist, shareKey(c))
+	if !access.View {
+		return db.Playlist{}, playlistAccess{}, errPlaylistNotFound
+	}
+
+	return playlist, access, nil
+}
+
+// editablePlaylistParam loads the playlist in the :id route parameter if user may change its songs,
+// or everything about it if owner is set
+func (s *Server) editablePlaylistParam(c *gin.Context, user db.User, owner bool) (db.Playlist, error) {
+	playlist, access, err := s.playlistParam(c, user)
+	if err != nil {
+		return db.Playlist{}, err
+	}
+
+	if !access.Edit || (owner && !access.Own) {
+		return db.Playlist{}, errPlaylistForbidden
+	}
+
+	return playlist, nil
+}
+
+// visiblePlaylistSongs returns the songs in a playlist which a user may see, in order
+func (s *Server) visiblePlaylistSongs(userID int, playlist db.Playlist) ([]db.PlaylistEntry, error) {
+	entries, err := s.DB.GetPlaylistSongs(playlist.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlisted songs need their own secret link, which a playlist does not give
+	var visible []db.PlaylistEntry
+	for _, entry := range entries {
+		if canView(userID, entry.Song, "") {
+			visible = append(visible, entry)
+		}
+	}
+
+	return visible, nil
+}
+
+// playlistSongByPublicID finds a song in a playlist by its public ID
+func (s *Server) playlistSongByPublicID(playlist db.Playlist, publicID string) (db.PlaylistEntry, error) {
+	entries, err := s.DB.GetPlaylistSongs(playlist.ID)
+	if err != nil {
+		return db.PlaylistEntry{}, err
+	}
+
+	for _, entry := range entries {
+		if entry.PublicID == publicID {
+			return entry, nil
+		}
+	}
+
+	return db.PlaylistEntry{}, errNotInPlaylist
+}
+
+// GetPlaylist gets the page of a playlist
+func (s *Server) GetPlaylist(c *gin.Context) {
+	user, _ := s.getCurrentUserFromDbBy(c)
+
+	playlist, access, err := s.playlistParam(c, user)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	s.renderPlaylist(c, http.StatusOK, user, playlist, access, nil)
+}
+
+// renderPlaylist renders playlist.tmpl with extra variables such as Error
+func (s *Server) renderPlaylist(c *gin.Context, status int, user db.User, playlist db.Playlist, access playlistAccess, extra gin.H) {
+	owner, err := s.DB.GetUserByID(playlist.UserID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	entries, err := s.visiblePlaylistSongs(user.ID, playlist)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var songs []*PlaylistEntryWithMeta
+	for _, entry := range entries {
+		songs = append(songs, &PlaylistEntryWithMeta{
+			PlaylistEntry: entry,
+			URL:           songURL(entry.Artist, entry.Song),
+			AudioURL:      songAudioURL(entry.Artist, entry.Song),
+		})
+	}
+
+	vars := gin.H{
+		"playlist":     playlist,
+		"playlistURL":  playlistURL(playlist),
+		"owner":        owner.Username,
+		"songs":        songs,
+		"canEdit":      access.Edit,
+		"isOwner":      access.Own,
+		"visibilities": visibilities,
+	}
+
+	if user.ID != 0 {
+		vars["currentUser"] = user.Username
+	}
+
+	if access.Own {
+		collaborators, err := s.DB.GetPlaylistCollaborators(playlist.ID)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		vars["collaborators"] = collaborators
+		if playlist.Visibility == db.VisibilityUnlisted {
+			vars["shareURL"] = playlistShareURL(playlist)
+		}
+	}
+
+	for key, value := range extra {
+		vars[key] = value
+	}
+
+	renderHTML(c, status, "playlist.tmpl", vars)
+}
+
+// PostPlaylist creates a playlist for the current user
+func (s *Server) PostPlaylist(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		c.String(errPlaylistTitleRequired.Status, errPlaylistTitleRequired.Error())
+		return
+	}
+
+	visibility, err := visibilityParam(c)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	id, err := s.DB.AddPlaylist(user.ID, title, strings.TrimSpace(c.PostForm("description")), visibility)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	playlist, err := s.DB.GetPlaylist(int(id))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, playlistURL(playlist))
+}
+
+// PostEditPlaylist changes the title, description and visibility of one of the current user's playlists
+func (s *Server) PostEditPlaylist(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	playlist, err := s.editablePlaylistParam(c, user, true)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		s.renderPlaylist(c, errPlaylistTitleRequired.Status, user, playlist, playlistAccess{View: true, Edit: true, Own: true}, gin.H{"Error": errPlaylistTitleRequired.Message})
+		return
+	}
+
+	visibility, err := visibilityParam(c)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	err = s.DB.UpdatePlaylist(playlist.ID, title, strings.TrimSpace(c.PostForm("description")), visibility)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, playlistURL(playlist))
+}
+
+// PostDeletePlaylist deletes one of the current user's playlists
+func (s *Server) PostDeletePlaylist(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	playlist, err := s.editablePlaylistParam(c, user, true)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if err := s.DB.DeletePlaylist(playlist.ID); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/user/"+url.PathEscape(user.Username))
+}
+
+// PostSongToPlaylist adds the song in the :id route parameter to the end of the playlist chosen in the form
+func (s *Server) PostSongToPlaylist(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	song, err := s.DB.GetSongByPublicID(c.Param("id"))
+	if err == sql.ErrNoRows || (err == nil && !canView(user.ID, song, shareKey(c))) {
+		c.String(http.StatusNotFound, errAPISongNotFound.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	playlist, err := s.DB.GetPlaylistByPublicID(c.PostForm("playlist"))
+	if err == sql.ErrNoRows {
+		err = errPlaylistNotFound
+	}
+	if err == nil && !s.playlistAccessFor(user.ID, playlist, "").Edit {
+		err = errPlaylistForbidden
+	}
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if err := s.DB.AddPlaylistSong(playlist.ID, song.ID, user.ID); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, playlistURL(playlist))
+}
+
+// PostRemovePlaylistSong takes the song chosen in the form out of a playlist
+func (s *Server) PostRemovePlaylistSong(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	playlist, err := s.editablePlaylistParam(c, user, false)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	entry, err := s.playlistSongByPublicID(playlist, c.PostForm("song"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if err := s.DB.RemovePlaylistSong(playlist.ID, entry.ID); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, playlistURL(playlist))
+}
+
+// PostPlaylistOrder saves the order songs were dragged into, sent as comma separated public IDs in the songs field
+func (s *Server) PostPlaylistOrder(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	playlist, err := s.editablePlaylistParam(c, user, false)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	entries, err := s.DB.GetPlaylistSongs(playlist.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ids := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		ids[entry.PublicID] = entry.ID
+	}
+
+	var order []int
+	for _, publicID := range strings.Split(c.PostForm("songs"), ",") {
+		publicID = strings.TrimSpace(publicID)
+		if publicID == "" {
+			continue
+		}
+
+		id, ok := ids[publicID]
+		if !ok {
+			c.String(errNotInPlaylist.Status, errNotInPlaylist.Error())
+			return
+		}
+		order = append(order, id)
+	}
+
+	if err := s.reorderPlaylist(playlist, order); err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// reorderPlaylist saves a new order of a playlist's songs
+func (s *Server) reorderPlaylist(playlist db.Playlist, songIDs []int) error {
+	err := s.DB.ReorderPlaylist(playlist.ID, songIDs)
+	if err == db.ErrNotInPlaylist {
+		return errNotInPlaylist
+	}
+
+	return err
+}
+
+// PostPlaylistCollaborator lets the user named in the form change the songs in one of the current user's playlists
+func (s *Server) PostPlaylistCollaborator(c *gin.Context) {
+	s.setPlaylistCollaborator(c, true)
+}
+
+// PostRemovePlaylistCollaborator stops the user named in the form changing the songs in one of the current user's playlists
+func (s *Server) PostRemovePlaylistCollaborator(c *gin.Context) {
+	s.setPlaylistCollaborator(c, false)
+}
+
+// setPlaylistCollaborator adds or removes a collaborator and goes back to the playlist
+func (s *Server) setPlaylistCollaborator(c *gin.Context, add bool) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	playlist, err := s.editablePlaylistParam(c, user, true)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	collaborator, err := s.DB.GetUserByName(strings.TrimSpace(c.PostForm("username")))
+	if err == sql.ErrNoRows || (err == nil && collaborator.ID == user.ID) {
+		s.renderPlaylist(c, http.StatusUnprocessableEntity, user, playlist, playlistAccess{View: true, Edit: true, Own: true}, gin.H{"Error": "Enter the name of another user"})
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if add {
+		err = s.DB.AddPlaylistCollaborator(playlist.ID, collaborator.ID)
+	} else {
+		err = s.DB.RemovePlaylistCollaborator(playlist.ID, collaborator.ID)
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, playlistURL(playlist))
+}
+
+// apiPlaylistFrom converts a playlist for the API, with songs if any are given
+func (s *Server) apiPlaylistFrom(playlist db.Playlist, owner string, entries []db.PlaylistEntry) apiPlaylist {
+	result := apiPlaylist{
+		ID:          playlist.PublicID,
+		Title:       playlist.Title,
+		Description: playlist.Description,
+		Owner:       owner,
+		Visibility:  playlist.Visibility,
+		URL:         playlistURL(playlist),
+		Created:     playlist.Created,
+		Updated:     playlist.Updated,
+	}
+
+	for _, entry := range entries {
+		result.Songs = append(result.Songs, s.apiSongFrom(entry.Song, entry.Artist))
+	}
+
+	return result
+}
+
+// APIGetPlaylist returns a playlist with the songs in it, in order
+func (s *Server) APIGetPlaylist(c *gin.Context) {
+	user, _ := s.apiCurrentUser(c)
+
+	playlist, _, err := s.playlistParam(c, user)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	owner, err := s.DB.GetUserByID(playlist.UserID)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	entries, err := s.visiblePlaylistSongs(user.ID, playlist)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	result := s.apiPlaylistFrom(playlist, owner.Username, entries)
+	if result.Songs == nil {
+		result.Songs = []apiSong{}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// APIGetUserPlaylists returns a user's public playlists, or all of them to the user themselves
+func (s *Server) APIGetUserPlaylists(c *gin.Context) {
+	user, err := s.DB.GetUserByName(c.Param("name"))
+	if err == sql.ErrNoRows {
+		apiAbort(c, errAPIUserNotFound)
+		return
+	}
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	var hidden bool
+	if current, err := s.apiCurrentUser(c); err == nil {
+		hidden = current.ID == user.ID
+	}
+
+	owned, err := s.DB.GetPlaylistsForUser(user.ID, hidden)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	playlists := []apiPlaylist{}
+	for _, playlist := range owned {
+		playlists = append(playlists, s.apiPlaylistFrom(playlist, user.Username, nil))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"playlists": playlists})
+}
+
+// APIPostPlaylist creates a playlist from a JSON body {"title": "...", "description": "...", "visibility": "public"}
+func (s *Server) APIPostPlaylist(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	var body struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apiAbort(c, newAPIError(http.StatusUnprocessableEntity, "Invalid playlist body"))
+		return
+	}
+
+	body.Title = strings.TrimSpace(body.Title)
+	if body.Title == "" {
+		apiAbort(c, errPlaylistTitleRequired)
+		return
+	}
+
+	if body.Visibility == "" {
+		body.Visibility = db.VisibilityPublic
+	}
+	if _, ok := visibilityByID(body.Visibility); !ok {
+		apiAbort(c, errUnknownVisibility)
+		return
+	}
+
+	id, err := s.DB.AddPlaylist(user.ID, body.Title, strings.TrimSpace(body.Description), body.Visibility)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	playlist, err := s.DB.GetPlaylist(int(id))
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, s.apiPlaylistFrom(playlist, user.Username, nil))
+}
+
+// APIPutPlaylistSong adds the song in the :songID route parameter to the end of a playlist
+func (s *Server) APIPutPlaylistSong(c *gin.Context) {
+	s.apiSetPlaylistSong(c, true)
+}
+
+// APIDeletePlaylistSong takes the song in the :songID route parameter out of a playlist
+func (s *Server) APIDeletePlaylistSong(c *gin.Context) {
+	s.apiSetPlaylistSong(c, false)
+}
+
+// apiSetPlaylistSong adds or removes a song the logged in user can see from a playlist they can change.
+// Unlisted songs are added with the key from their secret link in the songKey query parameter.
+func (s *Server) apiSetPlaylistSong(c *gin.Context, add bool) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	playlist, err := s.editablePlaylistParam(c, user, false)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	songID, err := apiIDParam(c, "songID")
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	song, err := s.DB.GetSong(songID)
+	if err == sql.ErrNoRows || (err == nil && add && !canView(user.ID, song, c.Query("songKey"))) {
+		err = errAPISongNotFound
+	}
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	if add {
+		err = s.DB.AddPlaylistSong(playlist.ID, song.ID, user.ID)
+	} else {
+		err = s.DB.RemovePlaylistSong(playlist.ID, song.ID)
+	}
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// APIPutPlaylistOrder reorders a playlist from a JSON body {"songs": [3, 1, 2]} of song IDs.
+// Songs left out keep their order after the ones given.
+func (s *Server) APIPutPlaylistOrder(c *gin.Context) {
+	user, err := s.apiCurrentUser(c)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	playlist, err := s.editablePlaylistParam(c, user, false)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	var body struct {
+		Songs []int `json:"songs"`
+	}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apiAbort(c, newAPIError(http.StatusUnprocessableEntity, "Invalid order body"))
+		return
+	}
+
+	if err := s.reorderPlaylist(playlist, body.Songs); err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
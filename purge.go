@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// purgeInterval is how often the background worker checks for purges to retry
+const purgeInterval = time.Minute
+
+// maxPurgeBackoff caps the delay between attempts to purge a bucket
+const maxPurgeBackoff = 6 * time.Hour
+
+// purgeBucket deletes every object in bucket and then the bucket itself.
+// Objects or buckets which are already gone are not an error so purges can be retried.
+func (s *Server) purgeBucket(ctx context.Context, bucket string) error {
+	objects, err := s.store.List(ctx, bucket)
+	if err == ErrObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		err := s.store.Delete(ctx, bucket, object.Path)
+		if err != nil && err != ErrObjectNotFound {
+			return err
+		}
+	}
+
+	err = s.store.DeleteBucket(ctx, bucket)
+	if err != nil && err != ErrObjectNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// attemptPurge tries to purge a bucket of a deleted account, scheduling a retry if it fails
+func (s *Server) attemptPurge(ctx context.Context, bucket string, attempts int) error {
+	err := s.purgeBucket(ctx, bucket)
+	if err != nil {
+		if deferErr := s.DB.DeferPurge(bucket, time.Now().Add(purgeBackoff(attempts)), err.Error()); deferErr != nil {
+			log.Printf("Failed to schedule purge of bucket %s: %s\n", bucket, deferErr)
+		}
+
+		return err
+	}
+
+	return s.DB.FinishPurge(bucket)
+}
+
+// purgeBackoff doubles the delay after every failed attempt
+func purgeBackoff(attempts int) time.Duration {
+	backoff := purgeInterval
+	for i := 0; i < attempts && backoff < maxPurgeBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxPurgeBackoff {
+		return maxPurgeBackoff
+	}
+
+	return backoff
+}
+
+// RunPurges retries leftover bucket purges until ctx is done
+func (s *Server) RunPurges(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purges, err := s.DB.GetDuePurges()
+		if err != nil {
+			log.Printf("Failed to load pending purges: %s\n", err)
+		}
+
+		for _, purge := range purges {
+			if err := s.attemptPurge(ctx, purge.Bucket, purge.Attempts); err != nil {
+				log.Printf("Failed to purge bucket %s (attempt %d): %s\n", purge.Bucket, purge.Attempts+1, err)
+				continue
+			}
+
+			log.Printf("Bucket %s purged\n", purge.Bucket)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
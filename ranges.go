@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errMultipleRanges      = errors.New("multiple ranges are not supported")
+	errUnsatisfiableRange  = errors.New("range not satisfiable")
+	errInvalidRangeRequest = errors.New("invalid range header")
+	errUnknownRangeUnit    = errors.New("range unit is not bytes")
+)
+
+// byteRange is a single range of bytes requested with the Range header
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange formats the Content-Range header for the range
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header for an object of size bytes.
+// Only a single range is supported, multiple ranges return errMultipleRanges.
+// Ranges in any unit other than bytes return errUnknownRangeUnit.
+func parseRange(header string, size int64) (byteRange, error) {
+	unit, spec, ok := strings.Cut(header, "=")
+	if !ok {
+		return byteRange{}, errInvalidRangeRequest
+	}
+	if !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return byteRange{}, errUnknownRangeUnit
+	}
+
+	spec = strings.TrimSpace(spec)
+	if strings.Contains(spec, ",") {
+		return byteRange{}, errMultipleRanges
+	}
+
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return byteRange{}, errInvalidRangeRequest
+	}
+
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	// Suffix range: the final N bytes of the object
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, errInvalidRangeRequest
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, errInvalidRangeRequest
+	}
+	if start >= size {
+		return byteRange{}, errUnsatisfiableRange
+	}
+
+	// Open ended range: everything from start
+	if last == "" {
+		return byteRange{start: start, length: size - start}, nil
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return byteRange{}, errInvalidRangeRequest
+	}
+	if end >= size {
+		end = size - 1
+	}
+
+	return byteRange{start: start, length: end - start + 1}, nil
+}
+
+// objectETag builds a strong ETag from an object's size and modification time
+func objectETag(info ObjectInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Modified.UnixNano(), info.Size)
+}
+
+// notModified checks If-None-Match and If-Modified-Since against an object
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err == nil && !modified.Truncate(time.Second).After(since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rangeApplies checks If-Range so a stale range is answered with the full object
+func rangeApplies(r *http.Request, etag string, modified time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+
+	since, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+
+	return modified.Truncate(time.Second).Equal(since)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+)
+
+// allowAttempt counts an attempt against key in redis and reports whether
+// fewer than limit attempts have been made within window
+func (s *Server) allowAttempt(key string, limit int64, window time.Duration) (bool, error) {
+	key = "attempts:" + key
+
+	count, err := s.redis.Incr(key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	// Start the window on the first attempt
+	if count == 1 {
+		if err := s.redis.Expire(key, window).Err(); err != nil {
+			return false, err
+		}
+	}
+
+	return count <= limit, nil
+}
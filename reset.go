@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// resetPurpose separates password reset tokens from other signed tokens
+const resetPurpose = "reset-password"
+
+// resetTTL is how long a password reset link stays valid
+const resetTTL = time.Hour
+
+// resetEmailLimit is how many reset emails can be requested for one address per hour
+const resetEmailLimit = 3
+
+// forgotMessage is shown whether or not the address belongs to an account
+const forgotMessage = "If an account uses that email address, a link to reset the password has been sent to it"
+
+// hashFingerprint identifies the current password hash so reset links stop working once it changes
+func hashFingerprint(hash []byte) string {
+	sum := sha256.Sum256(hash)
+	return hex.EncodeToString(sum[:8])
+}
+
+// sendPasswordReset emails a user a single use link to choose a new password
+func (s *Server) sendPasswordReset(user db.User) error {
+	hash, err := s.DB.GetUserHash(user.ID)
+	if err != nil {
+		return err
+	}
+
+	token := s.signToken(resetPurpose, fmt.Sprintf("%d:%s", user.ID, hashFingerprint(hash)), resetTTL)
+	link := s.siteURL + "/guest/reset?token=" + url.QueryEscape(token)
+
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your Tardigrad.io account. To choose a new password open this link:\n\n%s\n\nThe link expires in %d minutes and can only be used once. If you did not ask for this you can ignore this email.\n", user.Username, link, int(resetTTL.Minutes()))
+
+	return s.mailer.Send(user.Email, "Reset your Tardigrad.io password", body)
+}
+
+// userFromResetToken returns the user a reset token was issued to if it has not been used yet
+func (s *Server) userFromResetToken(token string) (db.User, error) {
+	subject, err := s.verifyToken(resetPurpose, token)
+	if err != nil {
+		return db.User{}, err
+	}
+
+	parts := strings.SplitN(subject, ":", 2)
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) != 2 {
+		return db.User{}, errTokenInvalid
+	}
+
+	user, err := s.DB.GetUserByID(userID)
+	if err != nil {
+		return db.User{}, errTokenInvalid
+	}
+
+	// The link is only valid until the password changes
+	hash, err := s.DB.GetUserHash(user.ID)
+	if err != nil || hashFingerprint(hash) != parts[1] {
+		return db.User{}, errTokenInvalid
+	}
+
+	return user, nil
+}
+
+// GetForgot is a Get Request to the /guest/forgot endpoint
+func (s *Server) GetForgot(c *gin.Context) {
+	renderHTML(c, http.StatusOK, "forgot.tmpl", gin.H{})
+}
+
+// PostForgot is a Post Request to the /guest/forgot endpoint
+func (s *Server) PostForgot(c *gin.Context) {
+	email := strings.TrimSpace(c.PostForm("email"))
+	if email == "" {
+		renderHTML(c, http.StatusUnprocessableEntity, "forgot.tmpl", gin.H{
+			"Error": "Enter the email address for your account",
+		})
+		return
+	}
+
+	allowed, err := s.allowAttempt("reset:"+strings.ToLower(email), resetEmailLimit, time.Hour)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !allowed {
+		renderHTML(c, http.StatusTooManyRequests, "forgot.tmpl", gin.H{
+			"Error": "Too many reset emails have been requested for this address, please try again later",
+		})
+		return
+	}
+
+	// Respond the same way for unknown addresses so accounts cannot be discovered
+	user, err := s.DB.GetUserByEmail(email)
+	if err == nil {
+		// The email is sent in the background so the response takes as long as it does for unknown addresses
+		go func() {
+			if err := s.sendPasswordReset(user); err != nil {
+				log.Printf("Failed to send password reset email to user %d: %s\n", user.ID, err)
+			}
+		}()
+	}
+
+	renderHTML(c, http.StatusOK, "forgot.tmpl", gin.H{
+		"Success": forgotMessage,
+	})
+}
+
+// GetReset is a Get Request to the /guest/reset endpoint
+func (s *Server) GetReset(c *gin.Context) {
+	token := c.Query("token")
+
+	if _, err := s.userFromResetToken(token); err != nil {
+		renderHTML(c, http.StatusBadRequest, "forgot.tmpl", gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	renderHTML(c, http.StatusOK, "reset.tmpl", gin.H{
+		"token": token,
+	})
+}
+
+// PostReset is a Post Request to the /guest/reset endpoint
+func (s *Server) PostReset(c *gin.Context) {
+	token := c.PostForm("token")
+	password := c.PostForm("password")
+
+	user, err := s.userFromResetToken(token)
+	if err != nil {
+		renderHTML(c, http.StatusBadRequest, "forgot.tmpl", gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if password == "" || password != c.PostForm("confirm") {
+		renderHTML(c, http.StatusUnprocessableEntity, "reset.tmpl", gin.H{
+			"token": token,
+			"Error": "Passwords do not match",
+		})
+		return
+	}
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Changing the hash uses up the link and logs the user out everywhere
+	err = s.DB.ResetUserHash(user.ID, hash)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.revokeAllSessions(user.ID, ""); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %s\n", user.ID, err)
+	}
+
+	renderHTML(c, http.StatusOK, "login.tmpl", gin.H{
+		"Success": "Your password has been reset, please log in",
+	})
+}
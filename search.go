@@ -0,0 +1,158 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+const (
+	// searchPageSize is how many songs are shown on each page of search results
+	searchPageSize = 20
+	// searchArtistLimit is how many artists are shown above the songs
+	searchArtistLimit = 10
+	// maxSearchPage keeps deep pages from being requested
+	maxSearchPage = 50
+)
+
+// SearchResultWithMeta contains a song found by a search with its matches highlighted for search.tmpl
+type SearchResultWithMeta struct {
+	db.SongSearchResult
+	URL         string
+	Created     string
+	TitleHTML   template.HTML
+	SnippetHTML template.HTML
+}
+
+// apiSearchResult is a song found by a search, with the matched words in titleHighlight and snippet wrapped in <mark>
+type apiSearchResult struct {
+	apiSong
+	TitleHighlight string `json:"titleHighlight"`
+	Snippet        string `json:"snippet"`
+}
+
+// markHTML escapes text from a search result and wraps the matched words in <mark>
+func markHTML(text string) template.HTML {
+	escaped := html.EscapeString(text)
+	escaped = strings.Replace(escaped, db.SearchMarkStart, "<mark>", -1)
+	escaped = strings.Replace(escaped, db.SearchMarkEnd, "</mark>", -1)
+	return template.HTML(escaped)
+}
+
+// searchPageParam reads the page of results asked for, counting from 1
+func searchPageParam(c *gin.Context) int {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+
+	if page > maxSearchPage {
+		return maxSearchPage
+	}
+
+	return page
+}
+
+// search finds the songs on a page of results and, on the first page, the artists matching query
+func (s *Server) search(query string, page int) ([]db.SongSearchResult, int, []db.User, error) {
+	songs, total, err := s.DB.SearchSongs(query, searchPageSize, (page-1)*searchPageSize)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	var artists []db.User
+	if page == 1 {
+		artists, err = s.DB.SearchUsers(query, searchArtistLimit)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	return songs, total, artists, nil
+}
+
+// GetSearch gets the search page for songs and artists
+func (s *Server) GetSearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	page := searchPageParam(c)
+
+	songs, total, artists, err := s.search(query, page)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var results []*SearchResultWithMeta
+	for _, song := range songs {
+		results = append(results, &SearchResultWithMeta{
+			SongSearchResult: song,
+			URL:              songURL(song.Artist, song.Song),
+			Created:          humanize.Time(time.Unix(int64(song.Created), 0)),
+			TitleHTML:        markHTML(song.TitleHighlight),
+			SnippetHTML:      markHTML(song.Snippet),
+		})
+	}
+
+	vars := gin.H{
+		"query":   query,
+		"results": results,
+		"artists": artists,
+		"total":   total,
+		"page":    page,
+	}
+
+	if page > 1 {
+		vars["prevPage"] = page - 1
+	}
+	if page*searchPageSize < total && page < maxSearchPage {
+		vars["nextPage"] = page + 1
+	}
+
+	if user, err := s.getCurrentUserFromDbBy(c); err == nil {
+		vars["currentUser"] = user.Username
+	}
+
+	renderHTML(c, http.StatusOK, "search.tmpl", vars)
+}
+
+// APISearch searches songs and artists for the q query parameter, a page of songs at a time
+func (s *Server) APISearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	page := searchPageParam(c)
+
+	songs, total, artists, err := s.search(query, page)
+	if err != nil {
+		apiAbort(c, err)
+		return
+	}
+
+	results := []apiSearchResult{}
+	for _, song := range songs {
+		results = append(results, apiSearchResult{
+			apiSong:        s.apiSongFrom(song.Song, song.Artist),
+			TitleHighlight: string(markHTML(song.TitleHighlight)),
+			Snippet:        string(markHTML(song.Snippet)),
+		})
+	}
+
+	users := []apiUser{}
+	for _, artist := range artists {
+		users = append(users, apiUser{ID: artist.ID, Username: artist.Username, Created: artist.Created, Avatar: apiAvatarFrom(artist)})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":   query,
+		"page":    page,
+		"perPage": searchPageSize,
+		"total":   total,
+		"songs":   results,
+		"artists": users,
+	})
+}
@@ -1,42 +1,49 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha512"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/tardigradio/website/audio"
 	"github.com/tardigradio/website/db"
-
-	"storj.io/storj/pkg/storage/streams"
-	"storj.io/storj/pkg/storj"
-	"storj.io/storj/pkg/stream"
-	"storj.io/storj/pkg/utils"
-	"storj.io/storj/storage"
 )
 
-// Server holds important info for accessing storj API and Tardigradio database
+// Server holds important info for accessing the audio store and Tardigradio database
 type Server struct {
-	DB       *db.DB
-	r        *gin.Engine
-	metainfo storj.Metainfo
-	ss       streams.Store
-	rs       storj.RedundancyScheme
-	es       storj.EncryptionScheme
+	DB           *db.DB
+	r            *gin.Engine
+	store        AudioStore
+	mailer       Mailer
+	secret       []byte
+	redis        *redis.Client
+	passwordCost int
+	siteURL      string // links in emails start with this
+
+	maxUploadSize int64 // bytes
+	maxAlbumSize  int64 // bytes all the tracks of an album upload may add up to
+	storageQuota  int64 // default bytes each user may upload
+
+	// reserved is the space set aside by reserveQuota for uploads still being stored, by user ID
+	reservedMu sync.Mutex
+	reserved   map[int]int64
 }
 
 // SongWithMeta contains information about a song and the artist
@@ -51,14 +58,52 @@ type SongWithMeta struct {
 type HomeVars struct {
 	RecentUploadedSongs []*SongWithMeta
 	RecentLikedSongs    []db.RecentlyLikedSong
+
+	// Feed is set when the songs are from the artists the user follows rather than everyone
+	Feed       bool
+	NextCursor string
+}
+
+// databasePath returns where the database of an AudioStore backend is kept
+func databasePath(homeDir, storeName string) string {
+	return filepath.Join(homeDir, fmt.Sprintf("/.tardigradio/%s/db.sqlite", storeName))
+}
+
+// printPendingMigrations lists the schema migrations the database of the configured backend is waiting for
+func printPendingMigrations(ctx context.Context) error {
+	usr, err := user.Current()
+	if err != nil {
+		return err
+	}
+
+	name, err := audioStoreName(usr.HomeDir)
+	if err != nil {
+		return err
+	}
+
+	pending, err := db.PendingMigrations(ctx, databasePath(usr.HomeDir, name))
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Printf("The database is up to date at version %d\n", db.LatestVersion())
+		return nil
+	}
+
+	for _, m := range pending {
+		fmt.Printf("%d\t%s\n", m.Version, m.Description)
+	}
+
+	return nil
 }
 
 // Initialize the Tardigradio Server
-func Initialize(ctx context.Context) *Server {
+func Initialize(ctx context.Context, rc *redis.Client) *Server {
 	router := gin.Default()
 
-	// Initialize the cookie store
-	store := cookie.NewStore([]byte(os.Getenv("SESSIONSECRET")))
+	// Initialize the session store, the cookie only holds a signed session ID
+	store := newRedisStore(rc, []byte(os.Getenv("SESSIONSECRET")))
 	router.Use(sessions.Sessions("mysession", store))
 
 	// Get current user account for determining Server Home Directory
@@ -67,29 +112,42 @@ func Initialize(ctx context.Context) *Server {
 		panic(err)
 	}
 
-	err = writeCert(ctx, usr.HomeDir)
+	// Connect to the configured object storage for songs
+	audioStore, satelliteid, err := openAudioStore(ctx, usr.HomeDir)
 	if err != nil {
 		panic(err)
 	}
 
-	// Get Storj Config
-	cfg := initConfig(usr.HomeDir)
-
-	meta, ss, err := cfg.Metainfo(ctx)
+	mailer, err := openMailer()
 	if err != nil {
 		panic(err)
 	}
 
-	satelliteid := cfg.Config.Client.OverlayAddr
+	site, err := siteURLFromEnv()
+	if err != nil {
+		panic(err)
+	}
 
 	// Open Database for storing tardigradio user data and upload meta
-	dbpath := filepath.Join(usr.HomeDir, fmt.Sprintf("/.tardigradio/%s/db.sqlite", satelliteid))
-	database, err := db.Open(ctx, dbpath)
+	database, err := db.Open(ctx, databasePath(usr.HomeDir, satelliteid))
 	if err != nil {
 		panic(err)
 	}
 
-	return &Server{DB: database, r: router, metainfo: meta, ss: ss, rs: cfg.GetRedundancyScheme(), es: cfg.GetEncryptionScheme()}
+	return &Server{
+		DB:           database,
+		r:            router,
+		store:        audioStore,
+		mailer:       mailer,
+		secret:       signingSecretFromEnv(),
+		redis:        rc,
+		passwordCost: passwordCostFromEnv(),
+		siteURL:      site,
+
+		maxUploadSize: bytesFromEnv("MAXUPLOADSIZE", defaultMaxUploadSize),
+		maxAlbumSize:  bytesFromEnv("MAXALBUMSIZE", defaultMaxAlbumSize),
+		storageQuota:  bytesFromEnv("STORAGEQUOTA", defaultStorageQuota),
+	}
 }
 
 // Run the Server using the gin Engine
@@ -104,226 +162,432 @@ func (s *Server) Close() error {
 
 // GetRoot will Get Request the "/" endpoint
 func (s *Server) GetRoot(c *gin.Context) {
-	session := sessions.Default(c)
-
 	// Determine the current user
 	var username string
-	user, err := s.getCurrentUserFromDbBy(session)
+	user, err := s.getCurrentUserFromDbBy(c)
 	if err == nil {
 		username = user.Username
 	}
 
-	// Get all songs uploaded in the last 24 hours
-	homevars, err := s.homeVariables()
+	cursor, err := parseFeedCursor(c.Query("before"))
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	homevars, err := s.homeVariables(user, cursor)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+	renderHTML(c, http.StatusOK, "index.tmpl", gin.H{
 		"recent":      homevars.RecentUploadedSongs,
 		"likedSongs":  homevars.RecentLikedSongs,
+		"feed":        homevars.Feed,
+		"nextCursor":  homevars.NextCursor,
 		"currentUser": username,
 	})
 	return
 }
 
-func (s *Server) homeVariables() (HomeVars, error) {
-	songs, err := s.GetRecentSongArray()
+// homeVariables gets the songs on the home page. Users who follow artists see a page of new songs
+// from them starting after cursor, everyone else sees the most recent uploads.
+func (s *Server) homeVariables(user db.User, cursor db.FeedCursor) (HomeVars, error) {
+	var vars HomeVars
+	var err error
+
+	if user.ID != 0 && s.DB.FollowingCount(user.ID) > 0 {
+		var feed []db.Song
+		var next db.FeedCursor
+
+		feed, next, err = s.DB.GetFeed(user.ID, cursor, feedPageSize)
+		if err != nil {
+			return HomeVars{}, err
+		}
+
+		vars.Feed = true
+		vars.NextCursor = formatFeedCursor(next)
+		vars.RecentUploadedSongs, err = s.songsWithMeta(feed)
+	} else {
+		vars.RecentUploadedSongs, err = s.GetRecentSongArray()
+	}
 	if err != nil {
 		return HomeVars{}, err
 	}
 
-	recentLikedSongs, err := s.DB.GetRecentLikedSongs()
+	vars.RecentLikedSongs, err = s.DB.GetRecentLikedSongs()
 	if err != nil {
 		return HomeVars{}, err
 	}
 
-	return HomeVars{RecentLikedSongs: recentLikedSongs, RecentUploadedSongs: songs}, nil
+	return vars, nil
 }
 
-// GetRecentSongArray returns an array of most recent songs
-func (s *Server) GetRecentSongArray() ([]*SongWithMeta, error) {
-	var songs []*SongWithMeta
+// renderHome renders index.tmpl for the current user with extra variables such as Error or Success
+func (s *Server) renderHome(c *gin.Context, status int, extra gin.H) {
+	user, _ := s.getCurrentUserFromDbBy(c)
 
-	recent, err := s.DB.GetRecentSongs()
+	homevars, err := s.homeVariables(user, db.FeedCursor{})
 	if err != nil {
-		return songs, err
+		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	// Create array of Recent Songs+Artist
-	for _, song := range recent {
-		user, err := s.DB.GetUserByID(song.UserID)
-		if err != nil {
-			return songs, err
-		}
+	vars := gin.H{
+		"recent":     homevars.RecentUploadedSongs,
+		"likedSongs": homevars.RecentLikedSongs,
+		"feed":       homevars.Feed,
+		"nextCursor": homevars.NextCursor,
+	}
 
-		likes := s.DB.RefLikeCount(song.ID)
+	if user.ID != 0 {
+		vars["currentUser"] = user.Username
+	}
 
-		songs = append(songs, &SongWithMeta{Song: song, Artist: user.Username, Created: humanize.Time(time.Unix(int64(song.Created), 0)), Likes: likes})
+	for key, value := range extra {
+		vars[key] = value
 	}
 
-	return songs, nil
+	renderHTML(c, status, "index.tmpl", vars)
 }
 
-// GetSong will Get the "/user/:name/*song" endpoint
-func (s *Server) GetSong(c *gin.Context) {
-	session := sessions.Default(c)
+// GetRecentSongArray returns an array of most recent songs
+func (s *Server) GetRecentSongArray() ([]*SongWithMeta, error) {
+	recent, err := s.DB.GetRecentSongs()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.songsWithMeta(recent)
+}
 
+// renderSong renders the page of one of user's songs
+func (s *Server) renderSong(c *gin.Context, user db.User, song db.Song) {
 	var currentUserName string
-	currentUser, err := s.getCurrentUserFromDbBy(session)
+	currentUser, err := s.getCurrentUserFromDbBy(c)
 	if err == nil {
 		currentUserName = currentUser.Username
 	}
 
-	username := c.Param("name")
-	title := strings.TrimPrefix(c.Param("song"), "/")
-
-	user, err := s.DB.GetUserByName(username)
+	threads, err := s.DB.GetCommentsForSong(song.ID)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Invalid username or password")
+		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	song, err := s.DB.GetSongByNameForUser(title, user.ID)
-	if err != nil {
+	// Listeners only see older versions if the artist shares them
+	var versions []*VersionWithMeta
+	if song.VersionsPublic || currentUser.ID == song.UserID {
+		versions, err = s.versionsWithMeta(user, song)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		for _, version := range versions {
+			version.AudioURL = withShareKey(c, song, version.AudioURL)
+		}
+	}
+
+	// Listeners can add the song to playlists they own or collaborate on
+	var playlists []db.Playlist
+	if currentUser.ID != 0 {
+		playlists, err = s.DB.GetEditablePlaylists(currentUser.ID)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Tracks link to their album, which the artist can move them between
+	var album *AlbumWithMeta
+	current, track, err := s.DB.GetSongAlbum(song.ID)
+	if err == nil {
+		album = albumWithMeta(current)
+	} else if err != sql.ErrNoRows {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "song.tmpl", gin.H{
-		"currentUser": currentUserName,
-		"username":    username,
-		"song":        song,
+	// Songs without a cover of their own show their album's
+	coverURL := imageURL(song.CoverID, "medium")
+	if coverURL == "" && album != nil {
+		coverURL = albumCoverURL(album.Album, "medium")
+	}
+
+	var albums []db.Album
+	if currentUser.ID == song.UserID {
+		albums, err = s.DB.GetAlbumsForUser(song.UserID)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Only the artist is shown who can find the song
+	var shareLink string
+	isOwner := currentUser.ID == song.UserID
+	if isOwner && song.Visibility == db.VisibilityUnlisted {
+		shareLink = shareURL(user.Username, song)
+	}
+
+	renderHTML(c, http.StatusOK, "song.tmpl", gin.H{
+		"currentUser":  currentUserName,
+		"username":     user.Username,
+		"song":         song,
+		"songURL":      songURL(user.Username, song),
+		"audioURL":     withShareKey(c, song, songAudioURL(user.Username, song)),
+		"shareKey":     shareKey(c),
+		"isOwner":      isOwner,
+		"shareURL":     shareLink,
+		"releaseTime":  releaseTime(song),
+		"playlists":    playlists,
+		"coverURL":     coverURL,
+		"album":        album,
+		"track":        track,
+		"albums":       albums,
+		"license":      songLicense(song),
+		"canDownload":  s.canDownload(c, song),
+		"versions":     versions,
+		"comments":     s.commentsWithMeta(threads, currentUser.ID, song.UserID, pageCSRFToken(c, "song.tmpl"), shareKey(c)),
+		"commentCount": s.DB.CommentCount(song.ID),
 	})
 }
 
-// DownloadSong will Post the "/user/:name/*song" endpoint
-// This endpoint downloads the song
-func (s *Server) DownloadSong(c *gin.Context) {
-	username := c.Param("name")
-	title := strings.TrimPrefix(c.Param("song"), "/")
+// serveSong streams a song's audio, honouring Range and conditional request headers
+func (s *Server) serveSong(c *gin.Context, artist string, song db.Song) {
+	// Audio plays in the page unless a download was asked for and the license allows it
+	disposition := "inline"
+	if c.Query("download") != "" {
+		if !s.canDownload(c, song) {
+			c.String(http.StatusForbidden, "The artist has not allowed this song to be downloaded")
+			return
+		}
 
-	// Look up song artist's ID by username
-	user, err := s.DB.GetUserByName(username)
+		disposition = "attachment"
+	}
+
+	download, info, err := s.store.Get(c, artist, song.Filename)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer download.Close()
+
+	etag := objectETag(info)
+
+	extraHeaders := map[string]string{
+		"Content-Disposition": fmt.Sprintf(`%s; filename="%s"`, disposition, path.Base(song.Filename)),
+		"Accept-Ranges":       "bytes",
+		"ETag":                etag,
+	}
+
+	if !info.Modified.IsZero() {
+		extraHeaders["Last-Modified"] = info.Modified.UTC().Format(http.TimeFormat)
+	}
+
+	// Let the browser reuse its cached copy
+	if notModified(c.Request, etag, info.Modified) {
+		for key, value := range extraHeaders {
+			c.Header(key, value)
+		}
+		c.Status(http.StatusNotModified)
+		return
+	}
 
-	song, err := s.DB.GetSongByNameForUser(title, user.ID)
+	rangeHeader := c.GetHeader("Range")
+	if rangeHeader == "" || !rangeApplies(c.Request, etag, info.Modified) {
+		c.DataFromReader(http.StatusOK, info.Size, "audio/*", download, extraHeaders)
+		return
+	}
+
+	// Only ranges which are valid but past the end are refused, any other Range header we cannot serve is ignored
+	byteRange, err := parseRange(rangeHeader, info.Size)
+	if err != nil && err != errUnsatisfiableRange {
+		c.DataFromReader(http.StatusOK, info.Size, "audio/*", download, extraHeaders)
+		return
+	}
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		c.String(http.StatusRequestedRangeNotSatisfiable, err.Error())
 		return
 	}
 
-	readOnlyStream, err := s.metainfo.GetObjectStream(c, username, song.Filename)
+	// Seek the download so only the requested bytes are fetched
+	_, err = download.Seek(byteRange.start, io.SeekStart)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	download := stream.NewDownload(c, readOnlyStream, s.ss)
-	defer utils.LogClose(download)
+	extraHeaders["Content-Range"] = byteRange.contentRange(info.Size)
 
-	extraHeaders := map[string]string{
-		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, song.Filename),
-	}
-
-	c.DataFromReader(http.StatusOK, readOnlyStream.Info().Size, "audio/*", download, extraHeaders)
-	return
+	c.DataFromReader(http.StatusPartialContent, byteRange.length, "audio/*", io.LimitReader(download, byteRange.length), extraHeaders)
 }
 
 // GetUpload gets the upload page
 func (s *Server) GetUpload(c *gin.Context) {
-	session := sessions.Default(c)
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	user, err := s.getCurrentUserFromDbBy(session)
+	vars, err := s.storageVariables(user)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "upload.tmpl", gin.H{
-		"currentUser": user.Username,
-	})
+	vars["currentUser"] = user.Username
+	vars["licenses"] = licenses
+	vars["defaultLicense"] = defaultLicense
+	vars["visibilities"] = visibilities
+	renderHTML(c, http.StatusOK, "upload.tmpl", vars)
 	return
 }
 
-// PostUpload uploads a song to the storj network and saves metainfo to Tardigrade database
+// PostUpload uploads a song to the audio store and saves metainfo to Tardigrade database
 func (s *Server) PostUpload(c *gin.Context) {
-	session := sessions.Default(c)
 	title := c.PostForm("songTitle")
 	description := c.PostForm("songDesc")
 
-	user, err := s.getCurrentUserFromDbBy(session)
+	user, err := s.getCurrentUserFromDbBy(c)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	fileHeader, _ := c.FormFile("file")
+	song, err := s.uploadSong(c, user, title, description)
+	if apiErr, ok := err.(*apiError); ok {
+		vars, err := s.storageVariables(user)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		vars["currentUser"] = user.Username
+		vars["licenses"] = licenses
+		vars["defaultLicense"] = defaultLicense
+		vars["visibilities"] = visibilities
+		vars["Error"] = apiErr.Message
+		if apiErr == errTitleRequired {
+			vars["Error"] = "Enter a title, the file does not have one"
+		}
+
+		renderHTML(c, apiErr.Status, "upload.tmpl", vars)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, songURL(user.Username, song))
+}
+
+// uploadSong saves the file uploaded in a multipart form as a new song, with the image in its cover field if one was chosen
+func (s *Server) uploadSong(c *gin.Context, user db.User, title, description string) (db.Song, error) {
+	fileHeader, err := s.uploadedFile(c)
+	if err != nil {
+		return db.Song{}, err
+	}
+
+	cover, err := preparedImageParam(c, "cover")
+	if err != nil {
+		return db.Song{}, err
+	}
+
+	size := fileHeader.Size
+	if cover != nil {
+		size += cover.Size
+	}
+
+	release, err := s.reserveQuota(user, size)
+	if err != nil {
+		return db.Song{}, err
+	}
+	defer release()
+
+	license, err := licenseParam(c)
+	if err != nil {
+		return db.Song{}, err
+	}
+
+	access, err := accessParam(c)
+	if err != nil {
+		return db.Song{}, err
+	}
 
 	file, err := fileHeader.Open()
 	if err != nil {
-		log.Fatal(err)
+		return db.Song{}, err
 	}
 	defer file.Close()
 
-	createInfo := storj.CreateObject{
-		RedundancyScheme: s.rs,
-		EncryptionScheme: s.es,
+	song, err := s.saveSong(c, user, title, description, license, access, fileHeader.Filename, file, fileHeader.Size)
+	if err != nil || cover == nil {
+		return song, err
 	}
 
-	obj, err := s.metainfo.CreateObject(c, user.Username, fileHeader.Filename, &createInfo)
+	picture, err := s.saveImage(c, user, cover)
+	if err == nil {
+		if err = s.DB.SetSongCover(song.ID, picture.PublicID); err != nil {
+			if deleteErr := s.deleteImage(c, user, picture.PublicID); deleteErr != nil {
+				log.Printf("Failed to delete unused image %s: %s\n", picture.PublicID, deleteErr)
+			}
+		}
+	}
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		s.discardSong(c, user, song)
+		return db.Song{}, err
 	}
 
-	reader := io.Reader(file)
+	return s.DB.GetSong(song.ID)
+}
 
-	mutableStream, err := obj.CreateStream(c)
+// saveSong uploads a song's audio to the user's bucket and records it in the database
+// along with the stream properties and tags read from the file.
+// The embedded title is used if title is blank.
+// The file is probed before it is stored rather than while it streams, since reading
+// an Ogg file's duration or an MP4 file's moov atom needs to seek to the end of the file.
+func (s *Server) saveSong(ctx context.Context, user db.User, title, description, license string, access db.SongAccess, filename string, file io.ReadSeeker, size int64) (db.Song, error) {
+	info, err := probeUpload(file, size)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return db.Song{}, err
 	}
 
-	upload := stream.NewUpload(c, mutableStream, s.ss)
+	return s.storeSong(ctx, user, title, description, license, access, filename, file, size, info)
+}
 
-	_, err = io.Copy(upload, reader)
-	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+// storeSong uploads a song's audio which has already been probed and records it in the database
+func (s *Server) storeSong(ctx context.Context, user db.User, title, description, license string, access db.SongAccess, filename string, file io.Reader, size int64, info audio.Info) (db.Song, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = strings.TrimSpace(info.Title)
 	}
 
-	if err := upload.Close(); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+	if title == "" {
+		return db.Song{}, errTitleRequired
 	}
 
-	err = s.DB.AddSong(title, description, fileHeader.Filename, user.ID)
+	key, err := s.putAudio(ctx, user, filename, file)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return db.Song{}, err
 	}
 
-	song, err := s.DB.GetSongByNameForUser(title, user.ID)
+	id, err := s.DB.AddSong(title, description, license, access, user.ID, db.SongFile{Filename: key, Size: size, SongMeta: songMetaFrom(info)})
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+		s.discardAudio(ctx, user, key)
+		return db.Song{}, err
 	}
 
-	c.HTML(http.StatusOK, "song.tmpl", gin.H{
-		"currentUser": user.Username,
-		"username":    user.Username,
-		"song":        song,
-	})
-	return
+	return s.DB.GetSong(int(id))
 }
 
 // GetUser gets the user account page
 func (s *Server) GetUser(c *gin.Context) {
-	session := sessions.Default(c)
 	username := c.Param("name")
 
 	var user db.User
@@ -336,31 +600,59 @@ func (s *Server) GetUser(c *gin.Context) {
 	type SongWithReadableCreated struct {
 		Song    db.Song
 		Created string
+		Release string
+	}
+
+	var currentUserName string
+	currentUser, err := s.getCurrentUserFromDbBy(c)
+	if err == nil {
+		currentUserName = currentUser.Username
 	}
 
-	uploads, err := s.DB.GetSongsForUser(user.ID)
+	// Artists also see their private, unlisted and scheduled songs
+	uploads, err := s.DB.GetSongsForUser(user.ID, currentUser.ID == user.ID)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	var songs []*SongWithReadableCreated
+	playlists, err := s.DB.GetPlaylistsForUser(user.ID, currentUser.ID == user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	for _, song := range uploads {
-		songs = append(songs, &SongWithReadableCreated{Song: song, Created: humanize.Time(time.Unix(int64(song.Created), 0))})
+	released, err := s.visibleAlbums(currentUser.ID, user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	var currentUserName string
-	currentUser, err := s.getCurrentUserFromDbBy(session)
-	if err == nil {
-		currentUserName = currentUser.Username
+	var albums []*AlbumWithMeta
+	for _, album := range released {
+		albums = append(albums, albumWithMeta(album))
 	}
 
-	c.HTML(http.StatusOK, "user.tmpl", gin.H{
-		"currentUser": currentUserName,
-		"username":    username,
-		"email":       user.Email,
-		"uploads":     songs,
+	var songs []*SongWithReadableCreated
+
+	for _, song := range uploads {
+		songs = append(songs, &SongWithReadableCreated{Song: song, Created: humanize.Time(time.Unix(int64(song.Created), 0)), Release: releaseTime(song)})
+	}
+
+	renderHTML(c, http.StatusOK, "user.tmpl", gin.H{
+		"currentUser":  currentUserName,
+		"username":     username,
+		"email":        user.Email,
+		"avatar":       avatarURL(user, "medium"),
+		"uploads":      songs,
+		"playlists":    playlists,
+		"albums":       albums,
+		"isSelf":       currentUser.ID != 0 && currentUser.ID == user.ID,
+		"visibilities": visibilities,
+		"followers":    s.DB.FollowerCount(user.ID),
+		"following":    s.DB.FollowingCount(user.ID),
+		"canFollow":    currentUser.ID != 0 && currentUser.ID != user.ID,
+		"isFollowing":  currentUser.ID != 0 && s.DB.IsFollowing(currentUser.ID, user.ID),
 	})
 	return
 }
@@ -369,8 +661,6 @@ func (s *Server) GetUser(c *gin.Context) {
 func (s *Server) ToggleLike(c *gin.Context) {
 	var err error
 	var result int
-	session := sessions.Default(c)
-
 	refID, err := strconv.Atoi(c.PostForm("refID"))
 
 	if err != nil {
@@ -380,7 +670,7 @@ func (s *Server) ToggleLike(c *gin.Context) {
 		return
 	}
 
-	refType, err := strconv.Atoi(c.PostForm("refType"))
+	refType, err := likeTypeFrom(c.PostForm("refType"))
 
 	if err != nil {
 		c.JSON(500, gin.H{
@@ -389,7 +679,14 @@ func (s *Server) ToggleLike(c *gin.Context) {
 		return
 	}
 
-	user, err := s.getCurrentUserFromDbBy(session)
+	if err := s.checkLikeTarget(c, refType, refID); err != nil {
+		c.JSON(statusFor(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err := s.getCurrentUserFromDbBy(c)
 	if err != nil {
 		c.JSON(500, gin.H{
 			"error": err.Error(),
@@ -397,10 +694,10 @@ func (s *Server) ToggleLike(c *gin.Context) {
 		return
 	}
 
-	isLiked := s.DB.IsLiked(user.ID, refID)
+	isLiked := s.DB.IsLiked(user.ID, refID, refType)
 
 	if isLiked {
-		err = s.DB.Dislike(user.ID, refID)
+		err = s.DB.Dislike(user.ID, refID, refType)
 		result = 0
 	} else {
 		err = s.DB.Like(user.ID, refID, refType)
@@ -422,7 +719,7 @@ func (s *Server) ToggleLike(c *gin.Context) {
 
 // GetLikeCount will return JSON indicating the amount of likes a refID has
 func (s *Server) GetLikeCount(c *gin.Context) {
-	refID, err := strconv.Atoi(c.PostForm("refID"))
+	refID, err := strconv.Atoi(c.Query("refID"))
 
 	if err != nil {
 		c.JSON(500, gin.H{
@@ -431,7 +728,23 @@ func (s *Server) GetLikeCount(c *gin.Context) {
 		return
 	}
 
-	count := s.DB.RefLikeCount(refID)
+	refType, err := likeTypeFrom(c.Query("refType"))
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := s.checkLikeTarget(c, refType, refID); err != nil {
+		c.JSON(statusFor(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	count := s.DB.RefLikeCount(refID, refType)
 
 	c.JSON(200, gin.H{
 		"result": count,
@@ -441,9 +754,16 @@ func (s *Server) GetLikeCount(c *gin.Context) {
 
 // IsLiked will return JSON indicating if a refID is liked
 func (s *Server) IsLiked(c *gin.Context) {
-	session := sessions.Default(c)
+	refID, err := strconv.Atoi(c.Query("refID"))
 
-	refID, err := strconv.Atoi(c.PostForm("refID"))
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	refType, err := likeTypeFrom(c.Query("refType"))
 
 	if err != nil {
 		c.JSON(500, gin.H{
@@ -452,7 +772,14 @@ func (s *Server) IsLiked(c *gin.Context) {
 		return
 	}
 
-	user, err := s.getCurrentUserFromDbBy(session)
+	if err := s.checkLikeTarget(c, refType, refID); err != nil {
+		c.JSON(statusFor(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err := s.getCurrentUserFromDbBy(c)
 	if err != nil {
 		c.JSON(200, gin.H{
 			"error:": err.Error(),
@@ -461,7 +788,7 @@ func (s *Server) IsLiked(c *gin.Context) {
 		return
 	}
 
-	isLiked := s.DB.IsLiked(user.ID, refID)
+	isLiked := s.DB.IsLiked(user.ID, refID, refType)
 
 	c.JSON(200, gin.H{
 		"result": isLiked,
@@ -469,64 +796,67 @@ func (s *Server) IsLiked(c *gin.Context) {
 	return
 }
 
-// GetSettings gets the account settings page
-func (s *Server) GetSettings(c *gin.Context) {
-	session := sessions.Default(c)
+// likeTypeFrom parses the refType parameter, defaulting to a song like
+func likeTypeFrom(refType string) (int, error) {
+	if refType == "" {
+		return db.SongType, nil
+	}
 
-	var currentUserName string
-	currentUser, err := s.getCurrentUserFromDbBy(session)
-	if err == nil {
-		currentUserName = currentUser.Username
+	likeType, err := strconv.Atoi(refType)
+	if err != nil {
+		return 0, err
 	}
 
-	c.HTML(http.StatusOK, "settings.tmpl", gin.H{
-		"currentUser": currentUserName,
-		"email":       currentUser.Email,
-	})
-	return
-}
+	if likeType != db.SongType && likeType != db.CommentType {
+		return 0, errors.New("Invalid like type")
+	}
 
-// DeleteSong will delete a song by the name
-func (s *Server) DeleteSong(c *gin.Context) {
-	session := sessions.Default(c)
+	return likeType, nil
+}
 
-	user, err := s.getCurrentUserFromDbBy(session)
+// GetSettings gets the account settings page
+func (s *Server) GetSettings(c *gin.Context) {
+	currentUser, err := s.getCurrentUserFromDbBy(c)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	songTitle := strings.TrimPrefix(c.Param("song"), "/")
+	s.renderSettings(c, http.StatusOK, currentUser, nil)
+	return
+}
 
-	song, err := s.DB.GetSongByNameForUser(songTitle, user.ID)
+// DeleteSong will delete one of the current user's songs by its public ID
+func (s *Server) DeleteSong(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Delete song meta from database
-	err = s.DB.DeleteSongByID(user.ID, song.ID)
+	song, err := s.ownSongParam(user, c.Param("id"))
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(statusFor(err), err.Error())
 		return
 	}
 
-	// Delete song from bucket
-	err = s.metainfo.DeleteObject(c, user.Username, song.Filename)
+	err = s.deleteSong(c, user, song)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	homevars, err := s.homeVariables()
+	homevars, err := s.homeVariables(user, db.FeedCursor{})
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+	renderHTML(c, http.StatusOK, "index.tmpl", gin.H{
 		"recent":      homevars.RecentUploadedSongs,
 		"likedSongs":  homevars.RecentLikedSongs,
+		"feed":        homevars.Feed,
+		"nextCursor":  homevars.NextCursor,
 		"currentUser": user.Username,
 		"Success":     "Successfully deleted song",
 	})
@@ -534,43 +864,75 @@ func (s *Server) DeleteSong(c *gin.Context) {
 	return
 }
 
+// deleteSong removes a user's song from the database and every version of it from their bucket
+func (s *Server) deleteSong(ctx context.Context, user db.User, song db.Song) error {
+	versions, err := s.DB.GetSongVersions(song.ID)
+	if err != nil {
+		return err
+	}
+
+	// Delete song meta from database
+	err = s.DB.DeleteSongByID(user.ID, song.ID)
+	if err != nil {
+		return err
+	}
+
+	// Delete song from bucket, carrying on past failures so as few objects as possible are left behind
+	var firstErr error
+	for _, version := range versions {
+		err := s.store.Delete(ctx, user.Username, version.Filename)
+		if err != nil && err != ErrObjectNotFound && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if err := s.deleteImage(ctx, user, song.CoverID); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
 // DeleteUser deletes a user
 func (s *Server) DeleteUser(c *gin.Context) {
-	session := sessions.Default(c)
-
-	user, err := s.getCurrentUserFromDbBy(session)
+	user, err := s.getCurrentUserFromDbBy(c)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	password := c.PostForm("password")
-	hash := getHashFrom([]byte(password))
 
-	if !s.Validated(user.ID, hash) {
+	if !s.Validated(user.ID, password) {
 		c.String(http.StatusInternalServerError, "Invalid username or password")
 		return
 	}
 
+	// Removes the user's rows and queues their bucket to be purged
 	err = s.DB.DeleteUser(user.ID)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// TODO: Delete all songs in bucket
-	// TODO: Delete Bucket
+	// If Storj is unavailable RunPurges retries in the background
+	if err := s.attemptPurge(c, user.Username, 0); err != nil {
+		log.Printf("Failed to purge bucket %s, will retry: %s\n", user.Username, err)
+	}
+
+	if err := s.revokeAllSessions(user.ID, sessions.Default(c).ID()); err != nil {
+		log.Printf("Failed to revoke sessions of deleted user %d: %s\n", user.ID, err)
+	}
 
-	session.Delete("user")
-	session.Save()
+	s.endSession(c)
 
-	homevars, err := s.homeVariables()
+	homevars, err := s.homeVariables(db.User{}, db.FeedCursor{})
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+	renderHTML(c, http.StatusOK, "index.tmpl", gin.H{
 		"recent":     homevars.RecentUploadedSongs,
 		"likedSongs": homevars.RecentLikedSongs,
 		"Success":    "Successfully deleted account",
@@ -578,25 +940,14 @@ func (s *Server) DeleteUser(c *gin.Context) {
 	return
 }
 
-// Validated validates a user
-func (s *Server) Validated(userID int, hash []byte) bool {
+// Validated validates a user's password
+func (s *Server) Validated(userID int, password string) bool {
 	userhash, err := s.DB.GetUserHash(userID)
 	if err != nil {
 		return false
 	}
 
-	if !bytes.Equal(hash, userhash) {
-		return false
-	}
-
-	return true
-}
-
-// getHashFrom will sha512 hash a byte slice
-func getHashFrom(salt []byte) []byte {
-	h := sha512.New()
-	h.Write(salt)
-	return h.Sum(nil)
+	return checkPassword(userhash, password)
 }
 
 // getCurrentUserFrom will Get Current User ID from cookies session
@@ -616,12 +967,19 @@ func getCurrentUserFrom(session sessions.Session) (int, error) {
 	}
 }
 
-// getCurrentUserFromDbBy will get a User object from the database by the current session user
-func (s *Server) getCurrentUserFromDbBy(session sessions.Session) (db.User, error) {
+// getCurrentUserFromDbBy will get a User object from the database by the API token or current session user
+func (s *Server) getCurrentUserFromDbBy(c *gin.Context) (db.User, error) {
 	var user db.User
 
+	// Get User ID from an API token accepted by AuthRequired
+	userID, err := getTokenUserFrom(c)
+	if err == nil {
+		return s.DB.GetUserByID(userID)
+	}
+
 	// Get User ID from Session
-	userID, err := getCurrentUserFrom(session)
+	session := sessions.Default(c)
+	userID, err = getCurrentUserFrom(session)
 	if err != nil {
 		return user, err
 	}
@@ -632,9 +990,22 @@ func (s *Server) getCurrentUserFromDbBy(session sessions.Session) (db.User, erro
 		return user, err
 	}
 
+	// Sessions started before the password was last reset are no longer valid
+	if getSessionGenerationFrom(session) != user.SessionGeneration {
+		return db.User{}, errors.New("Session has been revoked")
+	}
+
+	s.touchSession(c, session.ID(), user.ID)
+
 	return user, nil
 }
 
+// getSessionGenerationFrom will Get the session generation the user logged in with
+func getSessionGenerationFrom(session sessions.Session) int {
+	generation, _ := session.Get("generation").(int)
+	return generation
+}
+
 // PostLogin is a Post Request to the /guest/login enpoint
 func (s *Server) PostLogin(c *gin.Context) {
 	session := sessions.Default(c)
@@ -642,37 +1013,54 @@ func (s *Server) PostLogin(c *gin.Context) {
 	username := c.PostForm("username")
 	password := c.PostForm("password")
 
-	hash := getHashFrom([]byte(password))
-
 	// Look up User in database
 	user, err := s.DB.GetUserByName(username)
 	if err != nil {
-		c.HTML(http.StatusUnauthorized, "login.tmpl", gin.H{
+		renderHTML(c, http.StatusUnauthorized, "login.tmpl", gin.H{
 			"Error": "Invalid username or password",
 		})
 		return
 	}
 
 	// Verify user password
-	if !s.Validated(user.ID, hash) {
-		c.HTML(http.StatusUnauthorized, "login.tmpl", gin.H{
+	if !s.Validated(user.ID, password) {
+		renderHTML(c, http.StatusUnauthorized, "login.tmpl", gin.H{
 			"Error": "Invalid username or password",
 		})
 		return
 	}
 
-	session.Set("user", user.ID)
-	session.Save()
+	// Upgrade legacy or outdated password hashes now that we know the password
+	if err := s.rehashPassword(user.ID, password); err != nil {
+		log.Printf("Failed to upgrade password hash for user %d: %s\n", user.ID, err)
+	}
+
+	// Users with two-factor authentication enabled must also enter a code
+	secret, err := s.DB.GetTOTPSecret(user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if secret != "" {
+		startTwoFactor(session, user)
+		renderHTML(c, http.StatusOK, "twofactor.tmpl", gin.H{})
+		return
+	}
+
+	s.startSession(c, user)
 
-	homevars, err := s.homeVariables()
+	homevars, err := s.homeVariables(user, db.FeedCursor{})
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+	renderHTML(c, http.StatusOK, "index.tmpl", gin.H{
 		"recent":      homevars.RecentUploadedSongs,
 		"likedSongs":  homevars.RecentLikedSongs,
+		"feed":        homevars.Feed,
+		"nextCursor":  homevars.NextCursor,
 		"currentUser": username,
 		"Success":     "Successfully logged in",
 	})
@@ -681,39 +1069,51 @@ func (s *Server) PostLogin(c *gin.Context) {
 
 // GetLogin is a Get Request to the /guest/login enpoint
 func (s *Server) GetLogin(c *gin.Context) {
-	c.HTML(http.StatusOK, "login.tmpl", gin.H{})
+	renderHTML(c, http.StatusOK, "login.tmpl", gin.H{})
 	return
 }
 
 // PostRegister is a Post Request to the /guest/register enpoint
 func (s *Server) PostRegister(c *gin.Context) {
-	session := sessions.Default(c)
 	email := c.PostForm("email")
 	username := c.PostForm("username")
 	password := c.PostForm("password")
 
-	hash := getHashFrom([]byte(password))
+	if _, err := mail.ParseAddress(email); err != nil {
+		renderHTML(c, http.StatusUnprocessableEntity, "register.tmpl", gin.H{
+			"Error": "Failed to register user: Invalid email address",
+		})
+		return
+	}
 
-	// Storj: Check if Bucket already exists
-	_, err := s.metainfo.GetBucket(c, username)
-	if err == nil {
-		c.HTML(http.StatusInternalServerError, "register.tmpl", gin.H{
-			"Error": "Failed to register user: Bucket already exists",
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		renderHTML(c, http.StatusInternalServerError, "register.tmpl", gin.H{
+			"Error": fmt.Sprintf("Failed to register user: %s", err.Error()),
 		})
 		return
 	}
 
-	if !storage.ErrKeyNotFound.Has(err) {
-		c.HTML(http.StatusInternalServerError, "register.tmpl", gin.H{
+	// Check if Bucket already exists
+	exists, err := s.store.BucketExists(c, username)
+	if err != nil {
+		renderHTML(c, http.StatusInternalServerError, "register.tmpl", gin.H{
 			"Error": fmt.Sprintf("Failed to register user: %s", err.Error()),
 		})
 		return
 	}
 
-	// Storj: Create bucket tied to username
-	_, err = s.metainfo.CreateBucket(c, username, &storj.Bucket{PathCipher: storj.Cipher(1)})
+	if exists {
+		renderHTML(c, http.StatusInternalServerError, "register.tmpl", gin.H{
+			"Error": "Failed to register user: Bucket already exists",
+		})
+		return
+	}
+
+	// Create bucket tied to username
+	err = s.store.CreateBucket(c, username)
 	if err != nil {
-		c.HTML(http.StatusInternalServerError, "register.tmpl", gin.H{
+		renderHTML(c, http.StatusInternalServerError, "register.tmpl", gin.H{
 			"Error": fmt.Sprintf("Failed to register user: %s", err.Error()),
 		})
 		return
@@ -724,50 +1124,54 @@ func (s *Server) PostRegister(c *gin.Context) {
 	// Add user to database
 	id, err := s.DB.AddUser(email, username, hash)
 	if err != nil {
-		c.HTML(http.StatusInternalServerError, "register.tmpl", gin.H{
+		renderHTML(c, http.StatusInternalServerError, "register.tmpl", gin.H{
 			"Error": fmt.Sprintf("Failed to register user: %s", err.Error()),
 		})
 		return
 	}
 
-	session.Set("user", id)
-	session.Save()
+	s.startSession(c, db.User{ID: int(id)})
 
-	homevars, err := s.homeVariables()
+	// Uploading stays blocked until the email address is confirmed
+	success := "Successfully registered, check your email to confirm your address"
+	err = s.sendVerification(db.User{ID: int(id), Email: email, Username: username})
+	if err != nil {
+		log.Printf("Failed to send verification email to user %d: %s\n", id, err)
+		success = "Successfully registered, but we could not send a confirmation email. You can resend it from settings"
+	}
+
+	homevars, err := s.homeVariables(db.User{}, db.FeedCursor{})
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+	renderHTML(c, http.StatusOK, "index.tmpl", gin.H{
 		"recent":      homevars.RecentUploadedSongs,
 		"likedSongs":  homevars.RecentLikedSongs,
 		"currentUser": username,
-		"Success":     "Successfully registered",
+		"Success":     success,
 	})
 	return
 }
 
 // GetRegister is a Get Request to the /guest/register enpoint
 func (s *Server) GetRegister(c *gin.Context) {
-	c.HTML(http.StatusOK, "register.tmpl", gin.H{})
+	renderHTML(c, http.StatusOK, "register.tmpl", gin.H{})
 	return
 }
 
 // GetLogout gets the logout page
 func (s *Server) GetLogout(c *gin.Context) {
-	session := sessions.Default(c)
-
-	session.Delete("user")
-	session.Save()
+	s.endSession(c)
 
-	homevars, err := s.homeVariables()
+	homevars, err := s.homeVariables(db.User{}, db.FeedCursor{})
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+	renderHTML(c, http.StatusOK, "index.tmpl", gin.H{
 		"recent":     homevars.RecentUploadedSongs,
 		"likedSongs": homevars.RecentLikedSongs,
 		"Success":    "Successfully logged out",
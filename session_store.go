@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// errSessionRevoked is returned when saving a session which was revoked while the request was running
+var errSessionRevoked = errors.New("session has been revoked")
+
+// sessionRotateKey marks a session which should be given a new ID when it is next saved
+const sessionRotateKey = "rotateSessionID"
+
+// sessionKey is the redis key holding a session's values
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+// redisStore keeps session values in redis so they can be revoked.
+// The cookie only holds the signed session ID.
+type redisStore struct {
+	client  *redis.Client
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// newRedisStore creates a session store in redis which signs session IDs with keyPairs
+func newRedisStore(client *redis.Client, keyPairs ...[]byte) *redisStore {
+	return &redisStore{
+		client: client,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:     "/",
+			MaxAge:   86400 * 30,
+			Secure:   strings.HasPrefix(os.Getenv("SITEURL"), "https://"),
+			HttpOnly: true,
+			// Browsers leave the cookie off cross-site form posts
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// Options sets the cookie options for new sessions
+func (s *redisStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+// Get returns the session for name, cached for the rest of the request
+func (s *redisStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie.
+// Sessions which were revoked or have expired start again empty.
+func (s *redisStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	data, err := s.client.Get(sessionKey(id)).Bytes()
+	if err == redis.Nil {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, nil
+	}
+
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes the session values to redis and the signed ID to the cookie
+func (s *redisStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	// A negative MaxAge deletes the session
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.client.Del(sessionKey(session.ID)).Err(); err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if _, ok := session.Values[sessionRotateKey]; ok {
+		delete(session.Values, sessionRotateKey)
+
+		if session.ID != "" {
+			if err := s.client.Del(sessionKey(session.ID)).Err(); err != nil {
+				return err
+			}
+		}
+
+		session.ID = ""
+	}
+
+	// Sessions which already exist are only written while their key does,
+	// so a request still running when its session is revoked cannot bring it back
+	existing := session.ID != ""
+
+	if session.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+
+		session.ID = id
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+
+	if existing {
+		written, err := s.client.SetXX(sessionKey(session.ID), data.Bytes(), sessionTTL(session.Options)).Result()
+		if err != nil {
+			return err
+		}
+		if !written {
+			return errSessionRevoked
+		}
+	} else if err := s.client.Set(sessionKey(session.ID), data.Bytes(), sessionTTL(session.Options)).Err(); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// newSessionID generates a random session ID
+func newSessionID() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errTokenInvalid = errors.New("Invalid or tampered link")
+	errTokenExpired = errors.New("This link has expired")
+)
+
+// signingSecretFromEnv reads the secret used to sign emailed links.
+// TOKENSECRET is preferred, falling back to SESSIONSECRET.
+func signingSecretFromEnv() []byte {
+	secret := os.Getenv("TOKENSECRET")
+	if secret == "" {
+		secret = os.Getenv("SESSIONSECRET")
+	}
+
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	// Links signed with a random secret stop working when the server restarts
+	log.Println("TOKENSECRET and SESSIONSECRET are not set, using a random secret for signed links")
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+
+	return random
+}
+
+// signToken creates a token which proves subject for purpose until ttl has passed
+func (s *Server) signToken(purpose, subject string, ttl time.Duration) string {
+	payload := subject + "|" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(s.tokenMAC(purpose, payload))
+}
+
+// verifyToken checks a token created by signToken for purpose and returns its subject
+func (s *Server) verifyToken(purpose, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", errTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errTokenInvalid
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, s.tokenMAC(purpose, string(payload))) {
+		return "", errTokenInvalid
+	}
+
+	sep := strings.LastIndex(string(payload), "|")
+	if sep < 0 {
+		return "", errTokenInvalid
+	}
+
+	expires, err := strconv.ParseInt(string(payload[sep+1:]), 10, 64)
+	if err != nil {
+		return "", errTokenInvalid
+	}
+
+	if time.Now().Unix() > expires {
+		return "", errTokenExpired
+	}
+
+	return string(payload[:sep]), nil
+}
+
+// tokenMAC signs a payload, binding it to purpose so tokens cannot be reused for another flow
+func (s *Server) tokenMAC(purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrObjectNotFound is returned by an AudioStore when a bucket or object does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes an object held in an AudioStore
+type ObjectInfo struct {
+	Path     string
+	Size     int64
+	Modified time.Time
+}
+
+// AudioObject is an open object which can be read from any offset
+type AudioObject interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// AudioStore is the object storage songs are uploaded to and served from.
+// Every user owns a bucket named after their username.
+type AudioStore interface {
+	// BucketExists reports whether a bucket has already been created
+	BucketExists(ctx context.Context, bucket string) (bool, error)
+	// CreateBucket creates a new empty bucket
+	CreateBucket(ctx context.Context, bucket string) error
+	// Put streams data into a new object at path
+	Put(ctx context.Context, bucket, path string, data io.Reader) error
+	// Get opens an object for reading along with its size and modification time
+	Get(ctx context.Context, bucket, path string) (AudioObject, ObjectInfo, error)
+	// Delete removes an object
+	Delete(ctx context.Context, bucket, path string) error
+	// List returns every object in a bucket
+	List(ctx context.Context, bucket string) ([]ObjectInfo, error)
+	// DeleteBucket removes an empty bucket
+	DeleteBucket(ctx context.Context, bucket string) error
+}
+
+// audioStoreBackend returns the backend chosen by the AUDIOSTORE environment variable, storj if it is not set
+func audioStoreBackend() (string, error) {
+	switch backend := os.Getenv("AUDIOSTORE"); backend {
+	case "local":
+		return backend, nil
+	case "", "storj":
+		return "storj", nil
+	default:
+		return "", errors.New("unknown AUDIOSTORE backend: " + backend)
+	}
+}
+
+// audioStoreName returns a name for the configured backend, without connecting to it.
+// It is used to keep databases separate.
+func audioStoreName(homeDir string) (string, error) {
+	backend, err := audioStoreBackend()
+	if err != nil || backend == "local" {
+		return backend, err
+	}
+
+	return initConfig(homeDir).Config.Client.OverlayAddr, nil
+}
+
+// openAudioStore selects the AudioStore backend from the AUDIOSTORE environment variable.
+// It also returns the backend's audioStoreName.
+func openAudioStore(ctx context.Context, homeDir string) (AudioStore, string, error) {
+	backend, err := audioStoreBackend()
+	if err != nil {
+		return nil, "", err
+	}
+
+	name, err := audioStoreName(homeDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if backend == "local" {
+		root := os.Getenv("AUDIOSTOREPATH")
+		if root == "" {
+			root = filepath.Join(homeDir, ".tardigradio/local/objects")
+		}
+
+		store, err := newLocalStore(root)
+		return store, name, err
+	}
+
+	if err := writeCert(ctx, homeDir); err != nil {
+		return nil, "", err
+	}
+
+	// Get Storj Config
+	store, err := newStorjStore(ctx, initConfig(homeDir))
+	return store, name, err
+}
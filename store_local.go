@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localStore is an AudioStore that keeps buckets as directories on disk.
+// It is meant for local development where no Storj network is reachable.
+type localStore struct {
+	root string
+}
+
+// newLocalStore creates a localStore rooted at the root directory
+func newLocalStore(root string) (*localStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+
+	return &localStore{root: root}, nil
+}
+
+// BucketExists checks for the bucket directory
+func (s *localStore) BucketExists(ctx context.Context, bucket string) (bool, error) {
+	dir, err := s.bucketPath(bucket)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// CreateBucket creates the bucket directory
+func (s *localStore) CreateBucket(ctx context.Context, bucket string) error {
+	dir, err := s.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+
+	return os.Mkdir(dir, 0700)
+}
+
+// Put writes data to a file inside the bucket directory
+func (s *localStore) Put(ctx context.Context, bucket, path string, data io.Reader) error {
+	objectPath, err := s.objectPath(bucket, path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed upload never leaves a partial object.
+	// Each put gets its own, so uploads to the same path cannot write into each other's.
+	tmp, err := os.CreateTemp(filepath.Dir(objectPath), filepath.Base(objectPath)+".*.part")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), objectPath)
+}
+
+// Get opens the file backing an object
+func (s *localStore) Get(ctx context.Context, bucket, path string) (AudioObject, ObjectInfo, error) {
+	objectPath, err := s.objectPath(bucket, path)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	file, err := os.Open(objectPath)
+	if os.IsNotExist(err) {
+		return nil, ObjectInfo{}, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	return file, ObjectInfo{Path: path, Size: stat.Size(), Modified: stat.ModTime()}, nil
+}
+
+// Delete removes the file backing an object
+func (s *localStore) Delete(ctx context.Context, bucket, path string) error {
+	objectPath, err := s.objectPath(bucket, path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(objectPath)
+	if os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+
+	return err
+}
+
+// List walks the bucket directory for objects
+func (s *localStore) List(ctx context.Context, bucket string) ([]ObjectInfo, error) {
+	dir, err := s.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+
+	var objects []ObjectInfo
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || strings.HasSuffix(path, ".part") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, ObjectInfo{Path: filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+
+	return objects, err
+}
+
+// DeleteBucket removes the bucket directory along with any empty subdirectories
+func (s *localStore) DeleteBucket(ctx context.Context, bucket string) error {
+	dir, err := s.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+
+	objects, err := s.List(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	if len(objects) > 0 {
+		return errors.New("bucket is not empty")
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// bucketPath returns the directory for a bucket
+func (s *localStore) bucketPath(bucket string) (string, error) {
+	if bucket == "" || bucket == "." || bucket == ".." || strings.ContainsAny(bucket, `/\`) {
+		return "", errors.New("invalid bucket name")
+	}
+
+	return filepath.Join(s.root, bucket), nil
+}
+
+// objectPath returns the file for an object, refusing paths that escape the bucket
+func (s *localStore) objectPath(bucket, path string) (string, error) {
+	dir, err := s.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+
+	objectPath := filepath.Join(dir, filepath.FromSlash(path))
+	if !strings.HasPrefix(objectPath, dir+string(filepath.Separator)) {
+		return "", errors.New("invalid object path")
+	}
+
+	return objectPath, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"storj.io/storj/cmd/uplink/cmd"
+	"storj.io/storj/pkg/storage/streams"
+	"storj.io/storj/pkg/storj"
+	"storj.io/storj/pkg/stream"
+	"storj.io/storj/storage"
+)
+
+// storjStore is an AudioStore backed by the Storj network
+type storjStore struct {
+	metainfo storj.Metainfo
+	ss       streams.Store
+	rs       storj.RedundancyScheme
+	es       storj.EncryptionScheme
+}
+
+// newStorjStore connects to the Storj overlay and pointerdb described by cfg
+func newStorjStore(ctx context.Context, cfg cmd.Config) (*storjStore, error) {
+	meta, ss, err := cfg.Metainfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storjStore{metainfo: meta, ss: ss, rs: cfg.GetRedundancyScheme(), es: cfg.GetEncryptionScheme()}, nil
+}
+
+// BucketExists checks the Storj metainfo for bucket
+func (s *storjStore) BucketExists(ctx context.Context, bucket string) (bool, error) {
+	_, err := s.metainfo.GetBucket(ctx, bucket)
+	if err == nil {
+		return true, nil
+	}
+
+	if storage.ErrKeyNotFound.Has(err) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// CreateBucket creates a bucket with encrypted paths
+func (s *storjStore) CreateBucket(ctx context.Context, bucket string) error {
+	_, err := s.metainfo.CreateBucket(ctx, bucket, &storj.Bucket{PathCipher: storj.Cipher(1)})
+	return err
+}
+
+// Put uploads data to the Storj network
+func (s *storjStore) Put(ctx context.Context, bucket, path string, data io.Reader) error {
+	createInfo := storj.CreateObject{
+		RedundancyScheme: s.rs,
+		EncryptionScheme: s.es,
+	}
+
+	obj, err := s.metainfo.CreateObject(ctx, bucket, path, &createInfo)
+	if err != nil {
+		return err
+	}
+
+	mutableStream, err := obj.CreateStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	upload := stream.NewUpload(ctx, mutableStream, s.ss)
+
+	_, err = io.Copy(upload, data)
+	if err != nil {
+		_ = upload.Close()
+		return err
+	}
+
+	return upload.Close()
+}
+
+// Get opens a download stream from the Storj network
+func (s *storjStore) Get(ctx context.Context, bucket, path string) (AudioObject, ObjectInfo, error) {
+	readOnlyStream, err := s.metainfo.GetObjectStream(ctx, bucket, path)
+	if err != nil {
+		return nil, ObjectInfo{}, storjError(err)
+	}
+
+	return stream.NewDownload(ctx, readOnlyStream, s.ss), objectInfoFrom(readOnlyStream.Info()), nil
+}
+
+// Delete removes an object from the Storj network
+func (s *storjStore) Delete(ctx context.Context, bucket, path string) error {
+	return storjError(s.metainfo.DeleteObject(ctx, bucket, path))
+}
+
+// List pages through every object in bucket
+func (s *storjStore) List(ctx context.Context, bucket string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	opts := storj.ListOptions{Direction: storj.After, Recursive: true}
+	for {
+		list, err := s.metainfo.ListObjects(ctx, bucket, opts)
+		if err != nil {
+			return nil, storjError(err)
+		}
+
+		for _, item := range list.Items {
+			objects = append(objects, objectInfoFrom(item))
+		}
+
+		if !list.More {
+			break
+		}
+
+		opts = opts.NextPage(list)
+	}
+
+	return objects, nil
+}
+
+// DeleteBucket removes a bucket from the Storj metainfo
+func (s *storjStore) DeleteBucket(ctx context.Context, bucket string) error {
+	return storjError(s.metainfo.DeleteBucket(ctx, bucket))
+}
+
+// objectInfoFrom converts Storj object meta into ObjectInfo
+func objectInfoFrom(obj storj.Object) ObjectInfo {
+	return ObjectInfo{Path: obj.Path, Size: obj.Size, Modified: obj.Modified}
+}
+
+// storjError maps Storj not found errors to ErrObjectNotFound
+func storjError(err error) error {
+	if storage.ErrKeyNotFound.Has(err) {
+		return ErrObjectNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// API token scopes limit what a token can be used for
+const (
+	scopeRead   = "read"
+	scopeUpload = "upload"
+	scopeDelete = "delete"
+)
+
+// tokenScopes lists every scope a token can be granted
+var tokenScopes = []string{scopeRead, scopeUpload, scopeDelete}
+
+// tokenPrefix makes API tokens easy to recognise when they leak into logs
+const tokenPrefix = "tgio_"
+
+// tokenContextKey is where AuthRequired stores the db.APIToken used for a request
+const tokenContextKey = "apiToken"
+
+var (
+	errInvalidToken   = newAPIError(http.StatusUnauthorized, "Invalid API token")
+	errTokenScope     = newAPIError(http.StatusForbidden, "API token does not have the required scope")
+	errTokenForbidden = newAPIError(http.StatusForbidden, "API tokens cannot be used for this route")
+)
+
+// TokenWithMeta contains an API token with readable times for the settings page
+type TokenWithMeta struct {
+	Token    db.APIToken
+	Created  string
+	LastUsed string
+}
+
+// newAPIToken generates a random token and the hash that is stored for it
+func newAPIToken() (string, []byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	token := tokenPrefix + hex.EncodeToString(secret)
+	return token, hashAPIToken(token), nil
+}
+
+// hashAPIToken hashes a token for storage and lookup.
+// Tokens are random so a fast hash is enough.
+func hashAPIToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// authenticateToken checks the Authorization header for a bearer token.
+// It returns false if the request did not send a bearer token at all.
+func (s *Server) authenticateToken(c *gin.Context) (bool, error) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false, nil
+	}
+
+	token, err := s.DB.GetAPITokenByHash(hashAPIToken(strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))))
+	if err != nil {
+		return true, errInvalidToken
+	}
+
+	if err := s.DB.TouchAPIToken(token.ID); err != nil {
+		log.Printf("Failed to update last use of token %d: %s\n", token.ID, err)
+	}
+
+	c.Set(tokenContextKey, token)
+	return true, nil
+}
+
+// getTokenUserFrom will Get the User ID of the API token used for the request
+func getTokenUserFrom(c *gin.Context) (int, error) {
+	value, ok := c.Get(tokenContextKey)
+	if !ok {
+		return 0, errors.New("Request did not use an API token")
+	}
+
+	return value.(db.APIToken).UserID, nil
+}
+
+// RequireScope is a handler that requires API tokens to have been granted scope.
+// Requests authenticated by session are always allowed.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(tokenContextKey)
+		if ok && !value.(db.APIToken).HasScope(scope) {
+			apiAbort(c, errTokenScope)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SessionRequired is a handler that rejects API tokens on routes that manage the account itself
+func SessionRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(tokenContextKey); ok {
+			apiAbort(c, errTokenForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// settingsVariables returns the template variables shared by every render of settings.tmpl
+func (s *Server) settingsVariables(c *gin.Context, user db.User) (gin.H, error) {
+	tokens, err := s.DB.GetAPITokensForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokensWithMeta []*TokenWithMeta
+	for _, token := range tokens {
+		lastUsed := "never"
+		if token.LastUsed != 0 {
+			lastUsed = humanize.Time(time.Unix(int64(token.LastUsed), 0))
+		}
+
+		tokensWithMeta = append(tokensWithMeta, &TokenWithMeta{
+			Token:    token,
+			Created:  humanize.Time(time.Unix(int64(token.Created), 0)),
+			LastUsed: lastUsed,
+		})
+	}
+
+	secret, err := s.DB.GetTOTPSecret(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodesLeft, err := s.DB.RecoveryCodeCount(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := s.listDevices(user.ID, sessions.Default(c).ID())
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := s.storageVariables(user)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := gin.H{
+		"currentUser": user.Username,
+		"email":       user.Email,
+		"verified":    user.Verified,
+		"avatar":      avatarURL(user, "medium"),
+		"hasAvatar":   user.AvatarID != "",
+		"tokens":      tokensWithMeta,
+		"scopes":      tokenScopes,
+
+		"twoFactor":         secret != "",
+		"recoveryCodesLeft": recoveryCodesLeft,
+		"devices":           devices,
+	}
+
+	for key, value := range storage {
+		vars[key] = value
+	}
+
+	return vars, nil
+}
+
+// renderSettings renders settings.tmpl with extra variables such as Error or Success
+func (s *Server) renderSettings(c *gin.Context, status int, user db.User, extra gin.H) {
+	vars, err := s.settingsVariables(c, user)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for key, value := range extra {
+		vars[key] = value
+	}
+
+	renderHTML(c, status, "settings.tmpl", vars)
+}
+
+// PostToken creates a named API token and shows it to the user once
+func (s *Server) PostToken(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("tokenName"))
+	if name == "" || len(name) > 64 {
+		s.renderSettings(c, http.StatusUnprocessableEntity, user, gin.H{"Error": "Token names must be between 1 and 64 characters"})
+		return
+	}
+
+	scopes := c.PostFormArray("tokenScopes")
+	if len(scopes) == 0 {
+		s.renderSettings(c, http.StatusUnprocessableEntity, user, gin.H{"Error": "Select at least one scope for the token"})
+		return
+	}
+
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			s.renderSettings(c, http.StatusUnprocessableEntity, user, gin.H{"Error": "Unknown token scope " + scope})
+			return
+		}
+	}
+
+	token, hash, err := newAPIToken()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = s.DB.AddAPIToken(user.ID, name, hash, scopes)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{
+		"Success":  "Created token " + name + ". Copy it now, it will not be shown again.",
+		"newToken": token,
+	})
+}
+
+// RevokeToken deletes one of the user's API tokens
+func (s *Server) RevokeToken(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tokenID, err := strconv.Atoi(c.PostForm("tokenID"))
+	if err != nil {
+		s.renderSettings(c, http.StatusUnprocessableEntity, user, gin.H{"Error": "Invalid token"})
+		return
+	}
+
+	err = s.DB.DeleteAPIToken(user.ID, tokenID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Token revoked"})
+}
+
+// validScope checks if scope is one of tokenScopes
+func validScope(scope string) bool {
+	for _, known := range tokenScopes {
+		if scope == known {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"github.com/tardigradio/website/db"
+)
+
+// TOTP parameters from RFC 6238, using the defaults authenticator apps expect
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpModulus is 10^totpDigits
+	totpModulus = 1000000
+	// totpSkew is how many periods either side of now are accepted to allow for clock drift
+	totpSkew = 1
+)
+
+// recoveryCodeCount is how many one-time recovery codes are issued when 2FA is enabled
+const recoveryCodeCount = 10
+
+// twoFactorTimeout is how long a user has to enter their code after their password
+const twoFactorTimeout = 5 * time.Minute
+
+// twoFactorAttempts is how many codes can be tried per login within twoFactorTimeout
+const twoFactorAttempts = 5
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var errTwoFactorExpired = errors.New("Two-factor login has expired, please log in again")
+
+// newTOTPSecret generates a random base32 TOTP secret
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpURI returns the otpauth:// provisioning URI authenticator apps read from a QR code
+func totpURI(account, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", "Tardigrad.io")
+	values.Set("digits", strconv.Itoa(totpDigits))
+	values.Set("period", strconv.Itoa(totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape("Tardigrad.io:"+account) + "?" + values.Encode()
+}
+
+// totpQRCode renders a provisioning URI as a PNG data URI for settings.tmpl
+func totpQRCode(uri string) (template.URL, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+// setupVariables returns the template variables settings.tmpl needs to show a pending TOTP secret
+func setupVariables(user db.User, secret string) (gin.H, error) {
+	uri := totpURI(user.Username, secret)
+
+	qr, err := totpQRCode(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"totpSecret": secret,
+		"totpURI":    uri,
+		"totpQRCode": qr,
+	}, nil
+}
+
+// totpCode computes the code for a time step as described in RFC 4226 section 5.3
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// checkTOTP returns the time step code matches for secret, allowing for totpSkew
+func checkTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// newRecoveryCodes generates one-time recovery codes and the hashes that are stored for them
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	var codes []string
+	var hashes [][]byte
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		random := make([]byte, 5)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(random))
+		code = code[:4] + "-" + code[4:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code for storage and lookup, ignoring case and dashes
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// startTwoFactor remembers a user who has entered their password but not yet their code
+func startTwoFactor(session sessions.Session, user db.User) {
+	session.Delete("user")
+	session.Set("twoFactorUser", user.ID)
+	session.Set("twoFactorStarted", time.Now().Unix())
+	session.Save()
+}
+
+// getTwoFactorUserFrom will Get the User ID waiting on a two-factor code from the session
+func getTwoFactorUserFrom(session sessions.Session) (int, error) {
+	userID, ok := session.Get("twoFactorUser").(int)
+	started, _ := session.Get("twoFactorStarted").(int64)
+	if !ok || time.Since(time.Unix(started, 0)) > twoFactorTimeout {
+		return 0, errTwoFactorExpired
+	}
+
+	return userID, nil
+}
+
+// endTwoFactor forgets a pending two-factor login
+func endTwoFactor(session sessions.Session) {
+	session.Delete("twoFactorUser")
+	session.Delete("twoFactorStarted")
+	session.Save()
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery code
+func (s *Server) checkSecondFactor(userID int, secret, code string) (bool, error) {
+	if step, ok := checkTOTP(secret, code, time.Now()); ok {
+		// A code cannot be replayed within its validity window
+		return s.DB.UseTOTPStep(userID, step)
+	}
+
+	return s.DB.UseRecoveryCode(userID, hashRecoveryCode(code))
+}
+
+// GetTwoFactorLogin is a Get Request to the /guest/login/2fa endpoint
+func (s *Server) GetTwoFactorLogin(c *gin.Context) {
+	if _, err := getTwoFactorUserFrom(sessions.Default(c)); err != nil {
+		renderHTML(c, http.StatusBadRequest, "login.tmpl", gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	renderHTML(c, http.StatusOK, "twofactor.tmpl", gin.H{})
+}
+
+// PostTwoFactorLogin is a Post Request to the /guest/login/2fa endpoint
+func (s *Server) PostTwoFactorLogin(c *gin.Context) {
+	session := sessions.Default(c)
+
+	userID, err := getTwoFactorUserFrom(session)
+	if err != nil {
+		endTwoFactor(session)
+		renderHTML(c, http.StatusBadRequest, "login.tmpl", gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	allowed, err := s.allowAttempt("2fa:"+strconv.Itoa(userID), twoFactorAttempts, twoFactorTimeout)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !allowed {
+		endTwoFactor(session)
+		renderHTML(c, http.StatusTooManyRequests, "login.tmpl", gin.H{
+			"Error": "Too many incorrect codes, please try again later",
+		})
+		return
+	}
+
+	user, err := s.DB.GetUserByID(userID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	secret, err := s.DB.GetTOTPSecret(user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ok, err := s.checkSecondFactor(user.ID, secret, c.PostForm("code"))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !ok {
+		renderHTML(c, http.StatusUnauthorized, "twofactor.tmpl", gin.H{
+			"Error": "Invalid authentication code",
+		})
+		return
+	}
+
+	endTwoFactor(session)
+	s.startSession(c, user)
+
+	s.renderHome(c, http.StatusOK, gin.H{"Success": "Successfully logged in"})
+}
+
+// PostTwoFactorSetup generates a TOTP secret for the user to add to their authenticator app
+func (s *Server) PostTwoFactorSetup(c *gin.Context) {
+	session := sessions.Default(c)
+
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The secret is only saved once the user proves their app has it
+	session.Set("totpSetup", secret)
+	session.Save()
+
+	vars, err := setupVariables(user, secret)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, vars)
+}
+
+// PostTwoFactorEnable turns on two-factor authentication once the user enters a code from their app
+func (s *Server) PostTwoFactorEnable(c *gin.Context) {
+	session := sessions.Default(c)
+
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	secret, ok := session.Get("totpSetup").(string)
+	if !ok {
+		s.renderSettings(c, http.StatusBadRequest, user, gin.H{"Error": "Start two-factor authentication setup again"})
+		return
+	}
+
+	step, ok := checkTOTP(secret, c.PostForm("code"), time.Now())
+	if !ok {
+		vars, err := setupVariables(user, secret)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		vars["Error"] = "Invalid authentication code"
+		s.renderSettings(c, http.StatusUnprocessableEntity, user, vars)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = s.DB.EnableTOTP(user.ID, secret, hashes)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := s.DB.UseTOTPStep(user.ID, step); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	session.Delete("totpSetup")
+	session.Save()
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{
+		"Success":       "Two-factor authentication enabled. Save your recovery codes now, they will not be shown again.",
+		"recoveryCodes": codes,
+	})
+}
+
+// PostTwoFactorDisable turns off two-factor authentication after the user re-enters their password
+func (s *Server) PostTwoFactorDisable(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !s.Validated(user.ID, c.PostForm("password")) {
+		s.renderSettings(c, http.StatusUnauthorized, user, gin.H{"Error": "Incorrect password"})
+		return
+	}
+
+	err = s.DB.DisableTOTP(user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Two-factor authentication disabled"})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/audio"
+	"github.com/tardigradio/website/db"
+)
+
+const (
+	// defaultMaxUploadSize is used when MAXUPLOADSIZE is not set
+	defaultMaxUploadSize = 200 * 1000 * 1000
+	// defaultMaxAlbumSize is used when MAXALBUMSIZE is not set
+	defaultMaxAlbumSize = 1000 * 1000 * 1000
+	// defaultStorageQuota is used when STORAGEQUOTA is not set
+	defaultStorageQuota = 2 * 1000 * 1000 * 1000
+	// uploadFormOverhead leaves room for the other form fields and multipart headers
+	uploadFormOverhead = 64 * 1024
+)
+
+var (
+	errFileRequired      = newAPIError(http.StatusUnprocessableEntity, "A file is required")
+	errUnsupportedFormat = newAPIError(http.StatusUnsupportedMediaType, "Only MP3, FLAC, Ogg, M4A and WAV audio can be uploaded")
+)
+
+// bytesFromEnv reads a size in bytes such as "200MB" from name, falling back to fallback
+func bytesFromEnv(name string, fallback int64) int64 {
+	size, err := humanize.ParseBytes(os.Getenv(name))
+	if err != nil || size == 0 {
+		return fallback
+	}
+
+	return int64(size)
+}
+
+// errUploadTooLarge reports an upload over the size limit
+func errUploadTooLarge(limit int64) error {
+	return newAPIError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploads can be at most %s", humanize.Bytes(uint64(limit))))
+}
+
+// errQuotaExceeded reports an upload which does not fit in what is left of a user's quota
+func errQuotaExceeded(used, quota int64) error {
+	left := quota - used
+	if left < 0 {
+		left = 0
+	}
+
+	return newAPIError(http.StatusRequestEntityTooLarge, fmt.Sprintf("This upload does not fit in the %s left of your %s storage", humanize.Bytes(uint64(left)), humanize.Bytes(uint64(quota))))
+}
+
+// albumUploadRoutes are the routes whose requests carry every track of an album at once
+var albumUploadRoutes = map[string]bool{
+	"/active/albums": true,
+	"/api/v1/albums": true,
+}
+
+// uploadLimit returns the most bytes of files a request may carry and the error reported if it carries more.
+// Album uploads may be larger than single songs, but never larger than the space left in the uploader's quota.
+func (s *Server) uploadLimit(c *gin.Context) (int64, error) {
+	if c.Request.Method != http.MethodPost || !albumUploadRoutes[c.FullPath()] {
+		return s.maxUploadSize, errUploadTooLarge(s.maxUploadSize)
+	}
+
+	limit, tooLarge := s.maxAlbumSize, errUploadTooLarge(s.maxAlbumSize)
+
+	// Requests from no one are turned away by the authentication handlers after this
+	user, err := s.uploadingUser(c)
+	if err != nil {
+		return limit, tooLarge
+	}
+
+	used, quota, err := s.DB.StorageUsage(user.ID, s.storageQuota)
+	if err != nil || quota-used >= limit {
+		return limit, tooLarge
+	}
+
+	left := quota - used
+	if left < 0 {
+		left = 0
+	}
+
+	return left, errQuotaExceeded(used, quota)
+}
+
+// uploadingUser finds who sent a request by its API token or session, before the authentication handlers have run
+func (s *Server) uploadingUser(c *gin.Context) (db.User, error) {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token, err := s.DB.GetAPITokenByHash(hashAPIToken(strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))))
+		if err != nil {
+			return db.User{}, err
+		}
+
+		return s.DB.GetUserByID(token.UserID)
+	}
+
+	return s.getCurrentUserFromDbBy(c)
+}
+
+// BodyLimit is a handler that stops reading request bodies larger than the upload limit.
+// It must come before any handler which parses forms.
+func BodyLimit(server *Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		upload, err := server.uploadLimit(c)
+		limit := upload + uploadFormOverhead
+
+		if c.Request.ContentLength > limit {
+			c.Header("Connection", "close")
+			if isAPIRequest(c) {
+				apiAbort(c, err)
+			} else {
+				c.String(statusFor(err), err.Error())
+				c.Abort()
+			}
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// parseUpload parses a multipart upload, reporting bodies over the size limit
+func (s *Server) parseUpload(c *gin.Context) error {
+	if err := c.Request.ParseMultipartForm(s.r.MaxMultipartMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			_, err := s.uploadLimit(c)
+			return err
+		}
+
+		return errFileRequired
+	}
+
+	return nil
+}
+
+// uploadedFile parses a multipart upload and returns its file field
+func (s *Server) uploadedFile(c *gin.Context) (*multipart.FileHeader, error) {
+	if err := s.parseUpload(c); err != nil {
+		return nil, err
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, errFileRequired
+	}
+
+	if fileHeader.Size > s.maxUploadSize {
+		return nil, errUploadTooLarge(s.maxUploadSize)
+	}
+
+	return fileHeader, nil
+}
+
+// probeUpload reads the stream properties and tags of an uploaded file,
+// rejecting files which are not in one of the audio formats we accept.
+// file is the copy of the upload spooled by ParseMultipartForm, which is rewound for storing.
+func probeUpload(file io.ReadSeeker, size int64) (audio.Info, error) {
+	info, err := audio.Probe(file, size)
+	if err == audio.ErrUnknownFormat {
+		return info, errUnsupportedFormat
+	}
+	if err != nil {
+		return info, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	return info, err
+}
+
+// songMetaFrom converts what was read from an audio file into the metadata stored with a song
+func songMetaFrom(info audio.Info) db.SongMeta {
+	return db.SongMeta{
+		Duration:   info.Duration.Seconds(),
+		Bitrate:    info.Bitrate,
+		SampleRate: info.SampleRate,
+		Channels:   info.Channels,
+		Codec:      info.Codec,
+		Artist:     info.Artist,
+		Album:      info.Album,
+		Track:      info.Track,
+	}
+}
+
+// newObjectKey returns a path for an uploaded file in its owner's bucket.
+// Each upload gets its own directory, so files with the same name never replace each other.
+func newObjectKey(filename string) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "audio"
+	}
+
+	return hex.EncodeToString(random) + "/" + name, nil
+}
+
+// putAudio stores an uploaded file in the user's bucket and returns its object key
+func (s *Server) putAudio(ctx context.Context, user db.User, filename string, file io.Reader) (string, error) {
+	key, err := newObjectKey(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return key, s.store.Put(ctx, user.Username, key, file)
+}
+
+// discardAudio deletes an object which was stored but could not be recorded in the database
+func (s *Server) discardAudio(ctx context.Context, user db.User, key string) {
+	if err := s.store.Delete(ctx, user.Username, key); err != nil {
+		log.Printf("Failed to delete unused object %s/%s: %s\n", user.Username, key, err)
+	}
+}
+
+// reserveQuota sets aside size bytes of the user's storage quota for an upload, returning an error if there is not enough left.
+// The space stays reserved until release is called, which should be once the upload is recorded in the database or has failed,
+// so uploads running at the same time cannot together go over the quota.
+func (s *Server) reserveQuota(user db.User, size int64) (release func(), err error) {
+	s.reservedMu.Lock()
+	defer s.reservedMu.Unlock()
+
+	used, quota, err := s.DB.StorageUsage(user.ID, s.storageQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	used += s.reserved[user.ID]
+	if used+size > quota {
+		return nil, errQuotaExceeded(used, quota)
+	}
+
+	if s.reserved == nil {
+		s.reserved = make(map[int]int64)
+	}
+	s.reserved[user.ID] += size
+
+	return func() {
+		s.reservedMu.Lock()
+		defer s.reservedMu.Unlock()
+
+		s.reserved[user.ID] -= size
+		if s.reserved[user.ID] == 0 {
+			delete(s.reserved, user.ID)
+		}
+	}, nil
+}
+
+// storageVariables describes a user's storage use for the upload and settings pages
+func (s *Server) storageVariables(user db.User) (gin.H, error) {
+	used, quota, err := s.DB.StorageUsage(user.ID, s.storageQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	percent := 100
+	if used < quota {
+		percent = int(used * 100 / quota)
+	}
+
+	return gin.H{
+		"storageUsed":    humanize.Bytes(uint64(used)),
+		"storageQuota":   humanize.Bytes(uint64(quota)),
+		"storagePercent": strconv.Itoa(percent),
+		"maxUploadSize":  humanize.Bytes(uint64(s.maxUploadSize)),
+		"maxUploadBytes": s.maxUploadSize,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// verifyPurpose separates email verification tokens from other signed tokens
+const verifyPurpose = "verify-email"
+
+// verifyTTL is how long an email verification link stays valid
+const verifyTTL = 48 * time.Hour
+
+var errUnverified = newAPIError(http.StatusForbidden, "Verify your email address before uploading")
+
+// siteURLFromEnv reads the public address of the site, which links in emails point to, from SITEURL.
+// It is required since the Host header of a request is chosen by whoever sends it.
+func siteURLFromEnv() (string, error) {
+	site, err := url.Parse(os.Getenv("SITEURL"))
+	if err != nil || (site.Scheme != "http" && site.Scheme != "https") || site.Host == "" {
+		return "", errors.New("SITEURL must be set to the public address of the site, such as https://tardigrad.io")
+	}
+
+	return strings.TrimSuffix(site.String(), "/"), nil
+}
+
+// sendVerification emails a user a link to confirm their address
+func (s *Server) sendVerification(user db.User) error {
+	token := s.signToken(verifyPurpose, fmt.Sprintf("%d:%s", user.ID, user.Email), verifyTTL)
+	link := s.siteURL + "/verify?token=" + url.QueryEscape(token)
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address for Tardigrad.io by opening this link:\n\n%s\n\nThe link expires in %d hours.\n", user.Username, link, int(verifyTTL.Hours()))
+
+	return s.mailer.Send(user.Email, "Confirm your Tardigrad.io email address", body)
+}
+
+// VerifiedRequired is a handler that requires users to have confirmed their email address
+func VerifiedRequired(server *Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := server.getCurrentUserFromDbBy(c)
+		if err != nil {
+			apiAbort(c, errAPIUnauthorized)
+			return
+		}
+
+		if user.Verified {
+			c.Next()
+			return
+		}
+
+		if isAPIRequest(c) {
+			apiAbort(c, errUnverified)
+			return
+		}
+
+		renderHTML(c, http.StatusForbidden, "upload.tmpl", gin.H{
+			"currentUser": user.Username,
+			"unverified":  true,
+			"Error":       errUnverified.Error(),
+		})
+		c.Abort()
+	}
+}
+
+// isAPIRequest checks if a request was made to the JSON API
+func isAPIRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/api/")
+}
+
+// GetVerify confirms an email address from a link sent by sendVerification
+func (s *Server) GetVerify(c *gin.Context) {
+	subject, err := s.verifyToken(verifyPurpose, c.Query("token"))
+	if err != nil {
+		s.renderHome(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	parts := strings.SplitN(subject, ":", 2)
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) != 2 {
+		s.renderHome(c, http.StatusBadRequest, gin.H{"Error": errTokenInvalid.Error()})
+		return
+	}
+
+	// The link is only valid for the address it was sent to
+	user, err := s.DB.GetUserByID(userID)
+	if err != nil || user.Email != parts[1] {
+		s.renderHome(c, http.StatusBadRequest, gin.H{"Error": errTokenInvalid.Error()})
+		return
+	}
+
+	err = s.DB.SetUserVerified(user.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.renderHome(c, http.StatusOK, gin.H{"Success": "Your email address has been confirmed"})
+}
+
+// PostResendVerification sends another verification email to the current user
+func (s *Server) PostResendVerification(c *gin.Context) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if user.Verified {
+		s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Your email address is already confirmed"})
+		return
+	}
+
+	err = s.sendVerification(user)
+	if err != nil {
+		s.renderSettings(c, http.StatusInternalServerError, user, gin.H{"Error": fmt.Sprintf("Failed to send email: %s", err.Error())})
+		return
+	}
+
+	s.renderSettings(c, http.StatusOK, user, gin.H{"Success": "Sent a new confirmation email to " + user.Email})
+}
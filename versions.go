@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+var errVersionNotFound = newAPIError(http.StatusNotFound, "Version not found")
+
+// VersionWithMeta contains a song version and where its audio can be played from
+type VersionWithMeta struct {
+	db.SongVersion
+	Created  string
+	Current  bool
+	AudioURL string
+}
+
+// songVersionAudioURL returns the path a version of a song's audio is streamed from
+func songVersionAudioURL(artist string, song db.Song, number int) string {
+	return songURL(artist, song) + "/v/" + strconv.Itoa(number) + "/audio"
+}
+
+// versionsWithMeta lists the versions of one of artist's songs, newest first
+func (s *Server) versionsWithMeta(artist db.User, song db.Song) ([]*VersionWithMeta, error) {
+	versions, err := s.DB.GetSongVersions(song.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*VersionWithMeta
+	for _, version := range versions {
+		result = append(result, &VersionWithMeta{
+			SongVersion: version,
+			Created:     humanize.Time(time.Unix(int64(version.Created), 0)),
+			Current:     version.ID == song.CurrentVersion,
+			AudioURL:    songVersionAudioURL(artist.Username, song, version.Number),
+		})
+	}
+
+	return result, nil
+}
+
+// canHearVersions reports whether the current user may play and download every version of a song
+func (s *Server) canHearVersions(c *gin.Context, song db.Song) bool {
+	if song.VersionsPublic {
+		return true
+	}
+
+	user, err := s.getCurrentUserFromDbBy(c)
+	return err == nil && user.ID == song.UserID
+}
+
+// serveSongVersion streams the audio of an older version of a song
+func (s *Server) serveSongVersion(c *gin.Context, artist db.User, song db.Song, number int) {
+	if !s.canHearVersions(c, song) {
+		c.String(http.StatusForbidden, "Older versions of this song are private")
+		return
+	}
+
+	version, err := s.DB.GetSongVersion(song.ID, number)
+	if err == sql.ErrNoRows {
+		c.String(http.StatusNotFound, errVersionNotFound.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	song.Filename = version.Filename
+	s.serveSong(c, artist.Username, song)
+}
+
+// ownVersionParams loads one of the current user's songs and the number of the version being changed
+func (s *Server) ownVersionParams(c *gin.Context) (db.User, db.Song, int, error) {
+	user, err := s.getCurrentUserFromDbBy(c)
+	if err != nil {
+		return db.User{}, db.Song{}, 0, err
+	}
+
+	song, err := s.ownSongParam(user, c.Param("id"))
+	if err != nil {
+		return db.User{}, db.Song{}, 0, err
+	}
+
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		return db.User{}, db.Song{}, 0, errVersionNotFound
+	}
+
+	return user, song, number, nil
+}
+
+// PostCurrentVersion makes a version of one of the current user's songs the one which is played
+func (s *Server) PostCurrentVersion(c *gin.Context) {
+	user, song, number, err := s.ownVersionParams(c)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	err = s.DB.SetCurrentSongVersion(song.ID, number)
+	if err == sql.ErrNoRows {
+		err = errVersionNotFound
+	}
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, songURL(user.Username, song))
+}
+
+// PostDeleteVersion deletes a version of one of the current user's songs other than the current one
+func (s *Server) PostDeleteVersion(c *gin.Context) {
+	user, song, number, err := s.ownVersionParams(c)
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	filename, err := s.DB.DeleteSongVersion(song.ID, number)
+	if err == sql.ErrNoRows {
+		err = errVersionNotFound
+	}
+	if err == db.ErrCurrentVersion {
+		s.renderEditSong(c, http.StatusConflict, user, song, gin.H{"Error": "Choose another version to play before deleting this one"})
+		return
+	}
+	if err != nil {
+		c.String(statusFor(err), err.Error())
+		return
+	}
+
+	if err := s.store.Delete(c, user.Username, filename); err != nil && err != ErrObjectNotFound {
+		log.Printf("Failed to delete object of song version %s/%s: %s\n", user.Username, filename, err)
+	}
+
+	c.Redirect(http.StatusSeeOther, "/active/songs/"+song.PublicID+"/edit")
+}
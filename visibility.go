@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tardigradio/website/db"
+)
+
+// Visibility is one of the choices of who can find a song or playlist
+type Visibility struct {
+	ID   string
+	Name string
+	Hint string
+}
+
+// visibilities are offered in this order when uploading and editing songs and playlists
+var visibilities = []Visibility{
+	{ID: db.VisibilityPublic, Name: "Public", Hint: "Anyone can find and play it"},
+	{ID: db.VisibilityUnlisted, Name: "Unlisted", Hint: "Only people with the secret link can play it"},
+	{ID: db.VisibilityPrivate, Name: "Private", Hint: "Only you can play it"},
+}
+
+// releaseTimeLayout is the format of datetime-local inputs
+const releaseTimeLayout = "2006-01-02T15:04"
+
+var (
+	errUnknownVisibility = newAPIError(http.StatusUnprocessableEntity, "Choose who can find it from the list")
+	errInvalidReleaseAt  = newAPIError(http.StatusUnprocessableEntity, "Enter a valid release time")
+)
+
+// visibilityByID looks up a visibility by its ID
+func visibilityByID(id string) (Visibility, bool) {
+	for _, visibility := range visibilities {
+		if visibility.ID == id {
+			return visibility, true
+		}
+	}
+
+	return Visibility{}, false
+}
+
+// visibilityParam reads who can find a song or playlist from a form, public unless chosen otherwise
+func visibilityParam(c *gin.Context) (string, error) {
+	visibility := c.PostForm("visibility")
+	if visibility == "" {
+		return db.VisibilityPublic, nil
+	}
+
+	if _, ok := visibilityByID(visibility); !ok {
+		return "", errUnknownVisibility
+	}
+
+	return visibility, nil
+}
+
+// accessParam reads who can find a song and when it is released from a form.
+// Songs are public and released immediately unless chosen otherwise.
+// releaseAt is either RFC 3339 or the value of a datetime-local input, in which case
+// timezoneOffset holds the browser's offset from UTC in minutes as returned by Date.getTimezoneOffset.
+func accessParam(c *gin.Context) (db.SongAccess, error) {
+	visibility, err := visibilityParam(c)
+	if err != nil {
+		return db.SongAccess{}, err
+	}
+
+	access := db.SongAccess{Visibility: visibility}
+
+	releaseAt := strings.TrimSpace(c.PostForm("releaseAt"))
+	if releaseAt == "" {
+		return access, nil
+	}
+
+	release, err := time.Parse(time.RFC3339, releaseAt)
+	if err != nil {
+		release, err = time.Parse(releaseTimeLayout, releaseAt)
+		if err != nil {
+			return db.SongAccess{}, errInvalidReleaseAt
+		}
+
+		offset, err := strconv.Atoi(c.DefaultPostForm("timezoneOffset", "0"))
+		if err != nil {
+			return db.SongAccess{}, errInvalidReleaseAt
+		}
+		release = release.Add(time.Duration(offset) * time.Minute)
+	}
+
+	// A time that has already passed releases the song now
+	if release.After(time.Now()) {
+		access.ReleaseAt = release.Unix()
+	}
+
+	return access, nil
+}
+
+// shareKey returns the secret link key sent with a request, from the query string or a form
+func shareKey(c *gin.Context) string {
+	if key := c.Query("key"); key != "" {
+		return key
+	}
+
+	return c.PostForm("key")
+}
+
+// canView reports whether a user (ID 0 for guests) may see a song.
+// Artists always see their own songs. Everyone else sees released songs which are public,
+// or unlisted if key is the one in the song's secret link.
+func canView(userID int, song db.Song, key string) bool {
+	if userID != 0 && userID == song.UserID {
+		return true
+	}
+
+	if !song.Released(time.Now()) {
+		return false
+	}
+
+	switch song.Visibility {
+	case db.VisibilityPublic:
+		return true
+	case db.VisibilityUnlisted:
+		return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(song.ShareToken)) == 1
+	}
+
+	return false
+}
+
+// canViewSong reports whether the current user may see a song
+func (s *Server) canViewSong(c *gin.Context, song db.Song) bool {
+	var userID int
+	if user, err := s.getCurrentUserFromDbBy(c); err == nil {
+		userID = user.ID
+	}
+
+	return canView(userID, song, shareKey(c))
+}
+
+// withShareKey keeps the secret link key of an unlisted song on a path linked from its page
+func withShareKey(c *gin.Context, song db.Song, path string) string {
+	key := shareKey(c)
+	if song.Visibility != db.VisibilityUnlisted || key == "" {
+		return path
+	}
+
+	return path + "?key=" + url.QueryEscape(key)
+}
+
+// shareURL returns the secret link to an unlisted song
+func shareURL(artist string, song db.Song) string {
+	return songURL(artist, song) + "?key=" + url.QueryEscape(song.ShareToken)
+}
+
+// releaseTime formats when a scheduled song is released, or "" if it already is
+func releaseTime(song db.Song) string {
+	if song.Released(time.Now()) {
+		return ""
+	}
+
+	return time.Unix(song.ReleaseAt, 0).UTC().Format("Jan 2, 2006 15:04 UTC")
+}